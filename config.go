@@ -1,14 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/mitchellh/go-homedir"
 )
 
+const defaultProfile = "default"
+
 // Config is the config for all communications in harbor
 type Config struct {
 	ShipitURI    string `json:"shipit"`
@@ -17,66 +21,70 @@ type Config struct {
 	AuthURI      string `json:"authn"`
 	HelmitURI    string `json:"helmit"`
 	CustomsURI   string `json:"customs"`
-}
-
-func readConfig() (*Config, error) {
-	home, err := homedir.Dir()
-	if err != nil {
-		return nil, err
-	}
+	Credentials  string `json:"credentials,omitempty"`
 
-	curpath, _ := os.Getwd()
-
-	var hcConfig = os.Getenv("HC_CONFIG")
+	//named sections, each with its own endpoints and credentials path
+	//(the top-level values are the implicit "default" profile)
+	Profiles map[string]Config `json:"profiles,omitempty"`
+}
 
-	var path = home + "/.harbor"
-	err = os.Chdir(path)
-	if err != nil {
-		err = os.Mkdir(path, 0700)
+//readConfig reads the harbor config file without changing the working
+//directory or creating anything on disk (resources run in parallel goroutines)
+func readConfig(profile string) (*Config, error) {
+	configPath := os.Getenv("HC_CONFIG")
+	if configPath == "" {
+		home, err := homedir.Dir()
 		if err != nil {
 			return nil, err
 		}
+		configPath = filepath.Join(home, ".harbor", "config")
 	}
 
-	var configPath = path + "/config"
+	logDebug("reading harbor config: %v", configPath)
 
-	if hcConfig != "" {
-		configPath = hcConfig
+	byteData, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) || (err == nil && len(bytes.TrimSpace(byteData)) == 0) {
+		if profile != "" && profile != defaultProfile {
+			return nil, fmt.Errorf("profile '%v' requires a harbor config file (%v)", profile, configPath)
+		}
+		return new(Config), nil
 	}
-
-	if Verbose {
-		log.Println(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read harbor config: %v", err)
 	}
 
 	var serializedConfig Config
-	byteData, err := ioutil.ReadFile(configPath)
-	_ = os.Chdir(curpath)
-	if err != nil || isJSON(string(byteData)) == false {
-		serializedConfig := new(Config)
-		return serializedConfig, nil
-	}
-
 	err = json.Unmarshal(byteData, &serializedConfig)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("unable to parse harbor config (%v): %v", configPath, err)
 	}
 
-	return &serializedConfig, nil
+	return selectProfile(&serializedConfig, profile)
 }
 
-func isJSON(s string) bool {
-	var js map[string]interface{}
-	return json.Unmarshal([]byte(s), &js) == nil
+//selectProfile returns the named section of a config file
+func selectProfile(config *Config, profile string) (*Config, error) {
+	if profile == "" || profile == defaultProfile {
+		return config, nil
+	}
 
+	selected, ok := config.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("profile '%v' not found in harbor config", profile)
+	}
+	selected.Profiles = nil
+
+	return &selected, nil
 }
 
-// GetConfig will set the default values
-func GetConfig() *Config {
+// GetConfig reads the config for a profile and sets the default values.
+// It should be called once per provider instance and the result cached.
+func GetConfig(profile string) (*Config, error) {
 
-	var config, err = readConfig()
+	var config, err = readConfig(profile)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	if config.ShipitURI == "" {
@@ -103,5 +111,5 @@ func GetConfig() *Config {
 		config.CustomsURI = "https://customs.services.dmtio.net"
 	}
 
-	return config
+	return config, nil
 }
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/mitchellh/go-homedir"
+	harborauth "github.com/turnerlabs/harbor-auth-client"
+)
+
+var errTokenExpired = errors.New("Token has expired. Please run harbor-compose login")
+
+const (
+	envVarHarborUsername        = "HARBOR_USERNAME"
+	envVarHarborToken           = "HARBOR_TOKEN"
+	envVarHarborPassword        = "HARBOR_PASSWORD"
+	envVarHarborCredentialsFile = "HARBOR_CREDENTIALS_FILE"
+)
+
+//resolveCredentials walks the credential discovery chain and returns the first
+//credentials found along with a description of where they came from:
+// - credentials argument
+// - username/token arguments
+// - username/password arguments
+// - HARBOR_USERNAME/HARBOR_TOKEN environment variables
+// - HARBOR_USERNAME/HARBOR_PASSWORD environment variables
+// - HARBOR_CREDENTIALS_FILE
+// - the credentials file of the selected profile
+// - ~/.harbor/credentials
+//
+//when a password is found, the returned Auth has no token and must be logged in
+func resolveCredentials(d *schema.ResourceData, config *Config) (*Auth, string, error) {
+
+	//explicit json blob (e.g., "${file("~/.harbor/credentials")}")
+	if creds := d.Get("credentials").(string); creds != "" {
+		auth, err := parseCredentials([]byte(creds))
+		return auth, "credentials argument", err
+	}
+
+	//keep the password (if any) so that an expired token can be refreshed
+	if username, token := d.Get("username").(string), d.Get("token").(string); username != "" && token != "" {
+		return &Auth{Username: username, Token: token, password: d.Get("password").(string)}, "username/token arguments", nil
+	}
+
+	if username, password := d.Get("username").(string), d.Get("password").(string); username != "" && password != "" {
+		return &Auth{Username: username, password: password}, "username/password arguments", nil
+	}
+
+	if username, token := os.Getenv(envVarHarborUsername), os.Getenv(envVarHarborToken); username != "" && token != "" {
+		return &Auth{Username: username, Token: token, password: os.Getenv(envVarHarborPassword)}, fmt.Sprintf("%v/%v environment variables", envVarHarborUsername, envVarHarborToken), nil
+	}
+
+	if username, password := os.Getenv(envVarHarborUsername), os.Getenv(envVarHarborPassword); username != "" && password != "" {
+		return &Auth{Username: username, password: password}, fmt.Sprintf("%v/%v environment variables", envVarHarborUsername, envVarHarborPassword), nil
+	}
+
+	//an explicitly specified file must exist
+	if file := os.Getenv(envVarHarborCredentialsFile); file != "" {
+		auth, err := readCredentialsFile(file)
+		return auth, fmt.Sprintf("%v (%v)", envVarHarborCredentialsFile, file), err
+	}
+
+	//profiles can point to their own credentials file
+	if config.Credentials != "" {
+		auth, err := readCredentialsFile(config.Credentials)
+		return auth, config.Credentials, err
+	}
+
+	//fall back to the file written by harbor-compose login
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, "", err
+	}
+	file := filepath.Join(home, ".harbor", "credentials")
+	if _, err := os.Stat(file); err == nil {
+		auth, err := readCredentialsFile(file)
+		return auth, file, err
+	}
+
+	return nil, "", errors.New("missing credentials. Set the credentials, username/token or username/password provider arguments, the HARBOR_USERNAME/HARBOR_TOKEN, HARBOR_USERNAME/HARBOR_PASSWORD or HARBOR_CREDENTIALS_FILE environment variables, or run harbor-compose login")
+}
+
+func readCredentialsFile(file string) (*Auth, error) {
+	path, err := homedir.Expand(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file: %v", err)
+	}
+	return parseCredentials(data)
+}
+
+//deserialize credentials written by harbor-compose login
+func parseCredentials(data []byte) (*Auth, error) {
+	var auth Auth
+	err := json.Unmarshal(data, &auth)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse credentials: %v", err)
+	}
+	if auth.Username == "" || auth.Token == "" {
+		return nil, errors.New("credentials must contain a username and token")
+	}
+	return &auth, nil
+}
+
+//login exchanges a username/password for a token (kept in memory only)
+func (a *Auth) login() error {
+	client, err := harborauth.NewAuthClient(a.authURI)
+	if err != nil {
+		return err
+	}
+
+	token, success, err := client.Login(a.Username, a.password)
+	if err != nil {
+		return fmt.Errorf("login failed: %v", err)
+	}
+	if !success || token == "" {
+		return errors.New("login failed: invalid username or password")
+	}
+
+	registerSecret(token)
+	a.Token = token
+	return nil
+}
+
+//credentials returns the current username and token
+func (a *Auth) credentials() (string, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.Username, a.Token
+}
+
+//refresh logs in again after a request was rejected using expiredToken.
+//Only credentials that include a password can be refreshed.
+func (a *Auth) refresh(expiredToken string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	//another request already refreshed the token
+	if a.Token != expiredToken {
+		return nil
+	}
+
+	if a.password == "" {
+		return errTokenExpired
+	}
+
+	logDebug("harbor token expired, logging in again as %v", a.Username)
+	return a.login()
+}
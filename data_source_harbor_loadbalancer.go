@@ -56,6 +56,8 @@ func generateRandomID() string {
 }
 
 func dataSourceHarborLoadbalancerRead(d *schema.ResourceData, meta interface{}) error {
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
 	writeMetric(metricHarborLoadbalancerRead)
 	d.SetId(generateRandomID())
 
@@ -63,7 +65,7 @@ func dataSourceHarborLoadbalancerRead(d *schema.ResourceData, meta interface{})
 	environment := d.Get("environment").(string)
 
 	//query harbor for the lb status
-	result, err := getLoadBalancerStatus(shipment, environment)
+	result, err := client.getLoadBalancerStatus(ctx, shipment, environment)
 	if err != nil {
 		writeMetricError(metricHarborLoadbalancerRead, err)
 		return err
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+//snapshots older than this are ignored (and pruned) so that a create long after a plain
+//destroy doesn't restore stale images, env vars and build tokens
+const snapshotMaxAge = 24 * time.Hour
+
+//deletedEnvironments holds environments that were deleted (e.g., because of a ForceNew change)
+//so that the matching create can re-attach the user's images and env vars. resources are applied
+//in parallel, so entries are keyed by shipment::environment and only used by the same environment.
+//entries are also persisted as snapshot files so that they survive an apply that fails between
+//the delete and the create. snapshot files are kept in a directory per shipit endpoint, since
+//the same shipment::environment can exist on different harbor installations.
+type deletedEnvironments struct {
+	mu   sync.Mutex
+	dir  string
+	envs map[string]snapshot
+}
+
+//snapshot is a deleted environment (the contents of a snapshot file)
+type snapshot struct {
+	DeletedAt   time.Time           `json:"deletedAt"`
+	Environment ShipmentEnvironment `json:"environment"`
+}
+
+func (s snapshot) expired() bool {
+	return time.Since(s.DeletedAt) > snapshotMaxAge
+}
+
+//newDeletedEnvironments returns a store that persists snapshots of environments on the shipit
+//endpoint in a subdirectory of dir (~/.harbor/snapshots by default)
+func newDeletedEnvironments(dir string, endpoint string) (*deletedEnvironments, error) {
+	if dir == "" {
+		home, err := homedir.Dir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(home, ".harbor", "snapshots")
+	}
+	dir, err := homedir.Expand(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &deletedEnvironments{
+		dir:  filepath.Join(dir, escapeFileName(endpoint)),
+		envs: make(map[string]snapshot),
+	}
+	s.prune()
+	return s, nil
+}
+
+//put stores a deleted environment in memory and on disk
+func (s *deletedEnvironments) put(shipment string, env string, shipmentEnv *ShipmentEnvironment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//never persist the user's credentials
+	snap := snapshot{DeletedAt: time.Now(), Environment: *shipmentEnv}
+	snap.Environment.Username = ""
+	snap.Environment.Token = ""
+	s.envs[envID(shipment, env)] = snap
+
+	b, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	//snapshots contain the build token and hidden env vars, so keep them private
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(shipment, env), b, 0600)
+}
+
+//get returns the deleted environment for shipment::env (from memory or a snapshot file),
+//or nil if there isn't one (or it has expired)
+func (s *deletedEnvironments) get(shipment string, env string) (*ShipmentEnvironment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap, ok := s.envs[envID(shipment, env)]
+	if !ok {
+		b, err := ioutil.ReadFile(s.path(shipment, env))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return nil, fmt.Errorf("unable to read snapshot %v: %v", s.path(shipment, env), err)
+		}
+	}
+
+	if snap.expired() {
+		logInfo("ignoring snapshot of %v deleted at %v (older than %v)", envID(shipment, env), snap.DeletedAt, snapshotMaxAge)
+		delete(s.envs, envID(shipment, env))
+		if err := os.Remove(s.path(shipment, env)); err != nil && !os.IsNotExist(err) {
+			logWarn("unable to remove snapshot for %v: %v", envID(shipment, env), err)
+		}
+		return nil, nil
+	}
+
+	shipmentEnv := snap.Environment
+	return &shipmentEnv, nil
+}
+
+//remove discards the deleted environment once it has been successfully re-created
+func (s *deletedEnvironments) remove(shipment string, env string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.envs, envID(shipment, env))
+
+	err := os.Remove(s.path(shipment, env))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//prune removes expired snapshot files (e.g., from a plain terraform destroy)
+func (s *deletedEnvironments) prune() {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(s.dir, file.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var snap snapshot
+		if json.Unmarshal(b, &snap) != nil || !snap.expired() {
+			continue
+		}
+		logDebug("removing expired snapshot %v", path)
+		if err := os.Remove(path); err != nil {
+			logWarn("unable to remove expired snapshot %v: %v", path, err)
+		}
+	}
+}
+
+func (s *deletedEnvironments) path(shipment string, env string) string {
+	return filepath.Join(s.dir, snapshotFileName(shipment, env))
+}
+
+//snapshotFileName returns a file name that is valid on every os (e.g., windows doesn't allow ':'),
+//escaping anything other than letters, digits, '.' and '-' so that names can't collide
+func snapshotFileName(shipment string, env string) string {
+	return escapeFileName(shipment) + "_" + escapeFileName(env) + ".json"
+}
+
+func escapeFileName(name string) string {
+	var b bytes.Buffer
+	for _, c := range []byte(name) {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+//describeSnapshot summarizes what will be restored from a deleted environment
+func describeSnapshot(shipmentEnv *ShipmentEnvironment) string {
+	images := make([]string, len(shipmentEnv.Containers))
+	for i, container := range shipmentEnv.Containers {
+		images[i] = fmt.Sprintf("%v=%v", container.Name, container.Image)
+	}
+	envVars := copyUserDefinedEnvVars(shipmentEnv.EnvVars)
+	return fmt.Sprintf("images [%v], %v env var(s)", strings.Join(images, ", "), len(envVars))
+}
+
+//envID returns the terraform id for a shipment environment
+func envID(shipment string, env string) string {
+	return fmt.Sprintf("%s::%s", shipment, env)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testShipitURI = "http://shipit.example.com"
+
+func newTestDeletedEnvironments(t *testing.T) (*deletedEnvironments, string) {
+	dir, err := ioutil.TempDir("", "harbor-snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newDeletedEnvironments(dir, testShipitURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, dir
+}
+
+func testShipmentEnvironment(shipment string, env string) *ShipmentEnvironment {
+	return &ShipmentEnvironment{
+		Name:           env,
+		ParentShipment: ParentShipment{Name: shipment},
+		BuildToken:     fmt.Sprintf("build-token-%v-%v", shipment, env),
+		Containers: []ContainerPayload{
+			{Name: "web", Image: fmt.Sprintf("registry/%v:%v", shipment, env)},
+		},
+	}
+}
+
+//replaces several environments (delete then create) concurrently, as terraform does with parallelism > 1
+func TestDeletedEnvironmentsConcurrentReplace(t *testing.T) {
+	store, dir := newTestDeletedEnvironments(t)
+	defer os.RemoveAll(dir)
+
+	var wg sync.WaitGroup
+	for s := 0; s < 3; s++ {
+		for e := 0; e < 5; e++ {
+			shipment := fmt.Sprintf("shipment%v", s)
+			env := fmt.Sprintf("env%v", e)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				//delete
+				err := store.put(shipment, env, testShipmentEnvironment(shipment, env))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				//create
+				restored, err := store.get(shipment, env)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if restored == nil {
+					t.Errorf("%v::%v: nothing restored", shipment, env)
+					return
+				}
+				expected := testShipmentEnvironment(shipment, env)
+				if restored.Containers[0].Image != expected.Containers[0].Image {
+					t.Errorf("%v::%v: restored image %v from another environment", shipment, env, restored.Containers[0].Image)
+				}
+
+				err = store.remove(shipment, env)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				restored, err = store.get(shipment, env)
+				if err != nil {
+					t.Error(err)
+				}
+				if restored != nil {
+					t.Errorf("%v::%v: snapshot still exists after remove", shipment, env)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+//a snapshot survives the provider process (e.g., an apply that fails between delete and create)
+func TestDeletedEnvironmentsPersisted(t *testing.T) {
+	store, dir := newTestDeletedEnvironments(t)
+	defer os.RemoveAll(dir)
+
+	shipmentEnv := testShipmentEnvironment("app", "prod")
+	shipmentEnv.Token = "user-token-0123456789"
+	if err := store.put("app", "prod", shipmentEnv); err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := newDeletedEnvironments(dir, testShipitURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := next.get("app", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored == nil {
+		t.Fatal("snapshot wasn't restored")
+	}
+	if restored.Containers[0].Image != "registry/app:prod" || restored.BuildToken != shipmentEnv.BuildToken {
+		t.Errorf("unexpected snapshot: %+v", restored)
+	}
+	if restored.Token != "" {
+		t.Error("the user's token was persisted")
+	}
+
+	//other environments aren't affected
+	other, err := next.get("app", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other != nil {
+		t.Errorf("unexpected snapshot for app::dev: %+v", other)
+	}
+}
+
+//a snapshot left behind by a plain destroy isn't restored by a much later create
+func TestDeletedEnvironmentsExpire(t *testing.T) {
+	store, dir := newTestDeletedEnvironments(t)
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(store.dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range []string{"old", "older"} {
+		b, err := json.Marshal(snapshot{
+			DeletedAt:   time.Now().Add(-snapshotMaxAge - time.Hour),
+			Environment: *testShipmentEnvironment("app", env),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(store.path("app", env), b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restored, err := store.get("app", "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != nil {
+		t.Errorf("expired snapshot was restored: %+v", restored)
+	}
+	if _, err := os.Stat(store.path("app", "old")); !os.IsNotExist(err) {
+		t.Error("expired snapshot wasn't removed")
+	}
+
+	//expired snapshots are pruned when the provider starts
+	if _, err := newDeletedEnvironments(dir, testShipitURI); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.path("app", "older")); !os.IsNotExist(err) {
+		t.Error("expired snapshot wasn't pruned")
+	}
+}
+
+//provider aliases can target different harbor installations that share the snapshot directory
+func TestDeletedEnvironmentsPerEndpoint(t *testing.T) {
+	store, dir := newTestDeletedEnvironments(t)
+	defer os.RemoveAll(dir)
+
+	if err := store.put("app", "prod", testShipmentEnvironment("app", "prod")); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := newDeletedEnvironments(dir, "https://shipit.other.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := other.get("app", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != nil {
+		t.Errorf("restored a snapshot from another endpoint: %+v", restored)
+	}
+
+	//the same endpoint still sees it
+	same, err := newDeletedEnvironments(dir, testShipitURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err = same.get("app", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored == nil {
+		t.Error("snapshot wasn't restored for the same endpoint")
+	}
+}
+
+func TestSnapshotFileName(t *testing.T) {
+	names := map[string]bool{}
+	for _, key := range [][2]string{{"app", "prod"}, {"app_prod", ""}, {"app", "_prod"}, {"my-app", "dev.1"}, {"a:b", "c"}} {
+		name := snapshotFileName(key[0], key[1])
+		if strings.ContainsAny(name, `:\/*?"<>|`) || filepath.Base(name) != name {
+			t.Errorf("%v is not a valid file name", name)
+		}
+		if names[name] {
+			t.Errorf("%v::%v collides with another environment (%v)", key[0], key[1], name)
+		}
+		names[name] = true
+	}
+}
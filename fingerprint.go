@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+//fingerprinted fields whose last read value can be shown when describing a conflict
+var fingerprintScalars = []string{"barge", "replicas", "monitored", "iam_role"}
+
+//fingerprintFields returns a canonical representation of the parts of an environment that
+//terraform manages. images and user env vars aren't included since they're preserved on save.
+func fingerprintFields(shipmentEnv *ShipmentEnvironment) map[string]string {
+	fields := map[string]string{
+		"monitored": fmt.Sprint(shipmentEnv.EnableMonitoring),
+		"iam_role":  shipmentEnv.IamRole,
+	}
+
+	if provider := ec2Provider(shipmentEnv.Providers); provider != nil {
+		fields["barge"] = provider.Barge
+		fields["replicas"] = fmt.Sprint(provider.Replicas)
+	}
+
+	annotations := make(map[string]string, len(shipmentEnv.Annotations))
+	for _, anno := range shipmentEnv.Annotations {
+		annotations[anno.Key] = anno.Value
+	}
+	fields["annotations"] = canonicalJSON(annotations)
+
+	logShipping := []EnvVarPayload{}
+	for _, envVar := range shipmentEnv.EnvVars {
+		if logShippingEnvVars()[envVar.Name] != "" {
+			logShipping = append(logShipping, envVar)
+		}
+	}
+	sort.Slice(logShipping, func(i, j int) bool { return logShipping[i].Name < logShipping[j].Name })
+	fields["log_shipping"] = canonicalJSON(logShipping)
+
+	type container struct {
+		Name  string        `json:"name"`
+		Ports []PortPayload `json:"ports"`
+	}
+	containers := make([]container, len(shipmentEnv.Containers))
+	for i, c := range shipmentEnv.Containers {
+		containers[i] = container{Name: c.Name, Ports: c.Ports}
+	}
+	fields["container"] = canonicalJSON(containers)
+
+	return fields
+}
+
+//fingerprintShipmentEnvironment returns a hash per field, suitable for storing in state
+func fingerprintShipmentEnvironment(shipmentEnv *ShipmentEnvironment) map[string]string {
+	fingerprint := make(map[string]string)
+	for field, value := range fingerprintFields(shipmentEnv) {
+		sum := sha256.Sum256([]byte(value))
+		fingerprint[field] = hex.EncodeToString(sum[:8])
+	}
+	return fingerprint
+}
+
+//checkFingerprint returns an error describing any fields that were changed outside of terraform
+//since the environment was last read, i.e., changes that the bulk save would silently overwrite
+func checkFingerprint(d *schema.ResourceData, shipmentEnv *ShipmentEnvironment) error {
+	previous, ok := d.Get("fingerprint").(map[string]interface{})
+	if !ok || len(previous) == 0 {
+		//state written by an older version of the provider
+		return nil
+	}
+
+	current := fingerprintShipmentEnvironment(shipmentEnv)
+	fields := fingerprintFields(shipmentEnv)
+
+	var conflicts []string
+	for field, hash := range current {
+		if previous[field] == hash {
+			continue
+		}
+		conflicts = append(conflicts, describeConflict(d, field, fields[field]))
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	sort.Strings(conflicts)
+
+	shipment, env := idParts(d.Id())
+	return fmt.Errorf("%v was changed outside of terraform since it was last read:\n%v\nrun terraform refresh/plan to review the changes, or set force_overwrite = true to overwrite them",
+		envID(shipment, env), strings.Join(conflicts, "\n"))
+}
+
+func describeConflict(d *schema.ResourceData, field string, value string) string {
+	for _, scalar := range fingerprintScalars {
+		if field == scalar {
+			old, _ := d.GetChange(field)
+			return fmt.Sprintf("  %v: %v (last read) -> %v (shipit)", field, old, value)
+		}
+	}
+	return fmt.Sprintf("  %v: changed in shipit: %v", field, redactBody([]byte(value)))
+}
+
+//refreshFingerprint re-reads the environment after terraform has changed it
+func refreshFingerprint(ctx context.Context, client *HarborClient, d *schema.ResourceData, shipment string, env string) error {
+	shipmentEnv, err := client.GetShipmentEnvironment(ctx, shipment, env)
+	if err != nil {
+		return err
+	}
+	if shipmentEnv == nil {
+		return fmt.Errorf("%v doesn't exist", envID(shipment, env))
+	}
+	return d.Set("fingerprint", fingerprintShipmentEnvironment(shipmentEnv))
+}
+
+func canonicalJSON(v interface{}) string {
+	//maps are marshaled with sorted keys
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
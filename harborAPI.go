@@ -2,351 +2,545 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"sort"
 	"strings"
-
-	"github.com/jtacoma/uritemplates"
-	"github.com/parnurzeal/gorequest"
+	"time"
 )
 
 const providerEc2 = "ec2"
 
-func shipitURI(template string, params ...tuple) string {
-	return buildURI(GetConfig().ShipitURI, template, params...)
+// HarborClient is a client for the harbor apis (shipit, trigger, helmit, customs and catalogit)
+type HarborClient struct {
+	config  *Config
+	auth    *Auth
+	http    *http.Client
+	timeout time.Duration
+	retry   RetryPolicy
+
+	//dump full request/response exchanges (headers and bodies, redacted) at DEBUG
+	logHTTP bool
 }
 
-func helmitURI(template string, params ...tuple) string {
-	return buildURI(GetConfig().HelmitURI, template, params...)
+// NewHarborClient returns a client for the harbor installation described by config.
+// Each request is bounded by timeout (in addition to the caller's context)
+// and transient failures are retried according to retry.
+func NewHarborClient(config *Config, auth *Auth, timeout time.Duration, retry RetryPolicy) *HarborClient {
+	return &HarborClient{
+		config:  config,
+		auth:    auth,
+		http:    &http.Client{},
+		timeout: timeout,
+		retry:   retry,
+	}
 }
 
-func triggerURI(template string, params ...tuple) string {
-	return buildURI(GetConfig().TriggerURI, template, params...)
+func (c *HarborClient) shipitURI(template string, params ...tuple) (string, error) {
+	return buildURI(c.config.ShipitURI, template, params...)
 }
 
-func customsURI(template string, params ...tuple) string {
-	return buildURI(GetConfig().CustomsURI, template, params...)
+func (c *HarborClient) helmitURI(template string, params ...tuple) (string, error) {
+	return buildURI(c.config.HelmitURI, template, params...)
 }
 
-//GetShipment returns a top-level shipment
-func GetShipment(username string, token string, name string) *Shipment {
+func (c *HarborClient) triggerURI(template string, params ...tuple) (string, error) {
+	return buildURI(c.config.TriggerURI, template, params...)
+}
 
-	//build URI
-	uri := shipitURI("/v1/shipment/{shipment}", param("shipment", name))
-	if Verbose {
-		fmt.Println("fetching: " + uri)
-	}
+func (c *HarborClient) customsURI(template string, params ...tuple) (string, error) {
+	return buildURI(c.config.CustomsURI, template, params...)
+}
 
-	//issue request
-	request := gorequest.New().Get(uri)
+//send issues a request (serializing data as json, if specified) and returns the response body.
+//Transient failures are retried with backoff when it's safe to do so.
+func (c *HarborClient) send(ctx context.Context, method string, url string, headers map[string]string, data interface{}) (*http.Response, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		res, body, err := c.sendOnce(ctx, method, url, headers, data)
+		if attempt >= c.retry.MaxRetries || ctx.Err() != nil || !c.retry.shouldRetry(method, res, err) {
+			return res, body, err
+		}
 
-	//if token is specified, add it to the headers
-	if token != "" {
-		request = request.
-			Set("x-username", username).
-			Set("x-token", token)
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = fmt.Sprintf("status code = %v", res.StatusCode)
+		}
+		wait := c.retry.backoff(attempt, res)
+		logDebug("%v %v failed (%v), retrying in %v (%v/%v)", method, url, reason, wait, attempt+1, c.retry.MaxRetries)
+
+		if sleepErr := sleep(ctx, wait); sleepErr != nil {
+			return res, body, err
+		}
 	}
+}
+
+func (c *HarborClient) sendOnce(ctx context.Context, method string, url string, headers map[string]string, data interface{}) (*http.Response, []byte, error) {
 
-	resp, body, err := request.EndBytes()
+	var reqBody io.Reader
+	var reqBytes []byte
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		reqBytes = b
+		reqBody = bytes.NewReader(b)
+	}
 
+	req, err := http.NewRequest(method, url, reqBody)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
 	}
 
-	//return nil if the shipment/env isn't found
-	if resp.StatusCode == http.StatusNotFound {
-		return nil
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
 	}
+	req = req.WithContext(ctx)
 
-	if resp.StatusCode != http.StatusOK {
-		log.Fatal("GetShipment returned ", resp.StatusCode)
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
 	}
 
-	//deserialize json into object
-	var result Shipment
-	unmarshalErr := json.Unmarshal(body, &result)
-	if unmarshalErr != nil {
-		log.Fatal(unmarshalErr)
+	logTrace("%v %v", method, url)
+	if c.logHTTP {
+		logDebug("harbor http request:\n%v %v\n%v\n%v", method, url, redactHeaders(req.Header), redactBody(reqBytes))
 	}
 
-	return &result
+	res, err := c.http.Do(req)
+	if err != nil {
+		logTrace("%v %v failed: %v", method, url, err)
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logTrace("%v %v returned %v", method, url, res.StatusCode)
+	if c.logHTTP {
+		logDebug("harbor http response:\n%v %v\n%v\n%v", res.Proto, res.Status, redactHeaders(res.Header), redactBody(body))
+	}
+
+	return res, body, nil
 }
 
-// GetShipmentEnvironment returns a harbor shipment from the API
-func GetShipmentEnvironment(username string, token string, shipment string, env string) *ShipmentEnvironment {
+//sendAuthenticated issues a shipit request using the caller's credentials.  If the
+//token has expired mid-apply, it logs in again (when possible) and retries once.
+func (c *HarborClient) sendAuthenticated(ctx context.Context, method string, url string, data interface{}) (*http.Response, []byte, error) {
 
-	//build URI
-	uri := shipitURI("/v1/shipment/{shipment}/environment/{env}/",
-		param("shipment", shipment),
-		param("env", env))
+	send := func(username string, token string) (*http.Response, []byte, error) {
+
+		//payloads that carry credentials (e.g., bulk shipments) need the current token
+		if cc, ok := data.(credentialsCarrier); ok {
+			cc.setCredentials(username, token)
+		}
+
+		headers := map[string]string{}
+		if token != "" {
+			headers["x-username"] = username
+			headers["x-token"] = token
+		}
 
-	if Verbose {
-		fmt.Println("fetching: " + uri)
+		return c.send(ctx, method, url, headers, data)
 	}
 
-	//issue request
-	request := gorequest.New().Get(uri)
+	username, token := c.auth.credentials()
+	res, body, err := send(username, token)
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, body, err
+	}
 
-	//if token is specified, add it to the headers
-	if token != "" {
-		request = request.
-			Set("x-username", username).
-			Set("x-token", token)
+	if err := c.auth.refresh(token); err != nil {
+		return nil, nil, &UnauthorizedError{Op: method + " " + url, Message: err.Error()}
 	}
 
-	resp, body, err := request.EndBytes()
+	username, token = c.auth.credentials()
+	return send(username, token)
+}
+
+func (c *HarborClient) get(ctx context.Context, url string) (*http.Response, []byte, error) {
+	return c.sendAuthenticated(ctx, http.MethodGet, url, nil)
+}
+
+func (c *HarborClient) create(ctx context.Context, url string, data interface{}) (*http.Response, []byte, error) {
+	return c.sendAuthenticated(ctx, http.MethodPost, url, data)
+}
+
+func (c *HarborClient) update(ctx context.Context, url string, data interface{}) (*http.Response, []byte, error) {
+	return c.sendAuthenticated(ctx, http.MethodPut, url, data)
+}
+
+func (c *HarborClient) deleteHTTP(ctx context.Context, url string) (*http.Response, []byte, error) {
+	return c.sendAuthenticated(ctx, http.MethodDelete, url, nil)
+}
+
+//GetShipment returns a top-level shipment (nil if it doesn't exist)
+func (c *HarborClient) GetShipment(ctx context.Context, name string) (*Shipment, error) {
 
+	//build URI
+	uri, err := c.shipitURI("/v1/shipment/{shipment}", param("shipment", name))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	//return nil if the shipment/env isn't found
+	//issue request
+	resp, body, err := c.get(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	//return nil if the shipment isn't found
 	if resp.StatusCode == http.StatusNotFound {
-		return nil
+		return nil, nil
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		log.Fatal("GetShipment returned ", resp.StatusCode)
+		return nil, newResponseError("GetShipment", resp, body)
 	}
 
 	//deserialize json into object
-	var result ShipmentEnvironment
-	unmarshalErr := json.Unmarshal(body, &result)
-	if unmarshalErr != nil {
-		log.Fatal(unmarshalErr)
+	var result Shipment
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
 	}
 
-	//sort data so we can ensure consistent order when writing/reading
-	sortData(&result)
-
-	return &result
+	return &result, nil
 }
 
-//UpdateProvider updates provider configuration
-func UpdateProvider(username string, token string, shipment string, env string, provider ProviderPayload) {
-
-	uri := shipitURI("/v1/shipment/{shipment}/environment/{env}/provider/ec2",
-		param("shipment", shipment),
-		param("env", env))
+// GetShipmentEnvironments returns the names of a shipment's environments
+func (c *HarborClient) GetShipmentEnvironments(ctx context.Context, name string) ([]string, error) {
 
-	if Verbose {
-		log.Printf("updating replicas on shipment provider: " + uri)
+	uri, err := c.shipitURI("/v1/shipment/{shipment}", param("shipment", name))
+	if err != nil {
+		return nil, err
+	}
+	res, body, err := c.get(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, newResponseError("GetShipmentEnvironments", res, body)
 	}
 
-	//call the API
-	r, _, e := update(username, token, uri, provider)
-	if e != nil {
-		log.Fatal(e)
+	var result struct {
+		Environments []struct {
+			Name string `json:"name"`
+		} `json:"environments"`
 	}
-	if r.StatusCode != http.StatusOK {
-		check(errors.New("update provider failed"))
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
 	}
-}
 
-//UpdateShipmentEnvironment updates shipment/environment-level configuration
-func UpdateShipmentEnvironment(username string, token string, shipment string, composeShipment ComposeShipment) {
+	envs := make([]string, len(result.Environments))
+	for i, env := range result.Environments {
+		envs[i] = env.Name
+	}
+	sort.Strings(envs)
 
-	//update enableMonitoring
-	uri := shipitURI("/v1/shipment/{shipment}/environment/{env}",
-		param("shipment", shipment),
-		param("env", composeShipment.Env))
+	return envs, nil
+}
 
-	if Verbose {
-		log.Printf("updating enableMonitoring on shipment provider: " + uri)
+// CreateShipment creates a top-level shipment
+func (c *HarborClient) CreateShipment(ctx context.Context, shipment Shipment) error {
+
+	//POST /v1/shipments
+	uri, err := c.shipitURI("/v1/shipments")
+	if err != nil {
+		return err
+	}
+	res, body, err := c.create(ctx, uri, shipment)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusCreated {
+		return newResponseError("unable to create shipment", res, body)
 	}
 
-	request := UpdateShipmentEnvironmentRequest{
-		EnableMonitoring: *composeShipment.EnableMonitoring,
+	return nil
+}
+
+// UpdateShipment updates a top-level shipment
+func (c *HarborClient) UpdateShipment(ctx context.Context, name string, shipment Shipment) error {
+
+	uri, err := c.shipitURI("/v1/shipment/{shipment}", param("shipment", name))
+	if err != nil {
+		return err
+	}
+	res, body, err := c.update(ctx, uri, shipment)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return newResponseError("shipment update failed", res, body)
 	}
 
-	//call the API
-	r, _, e := update(username, token, uri, request)
-	if e != nil {
-		log.Fatal(e)
+	return nil
+}
+
+// DeleteShipment deletes a top-level shipment
+func (c *HarborClient) DeleteShipment(ctx context.Context, name string) error {
+
+	uri, err := c.shipitURI("/v1/shipment/{shipment}", param("shipment", name))
+	if err != nil {
+		return err
 	}
-	if r.StatusCode != http.StatusOK {
-		check(errors.New("update provider failed"))
+	res, body, err := c.deleteHTTP(ctx, uri)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return newResponseError("shipment delete failed", res, body)
 	}
+
+	return nil
 }
 
-func create(username string, token string, url string, data interface{}) (*http.Response, string, []error) {
+// CreateShipmentEnvVar creates a shipment-level environment variable
+func (c *HarborClient) CreateShipmentEnvVar(ctx context.Context, shipment string, envVar EnvVarPayload) error {
 
-	if Verbose {
-		log.Printf("POST %v", url)
+	//POST /v1/shipment/:Shipment/envVars
+	uri, err := c.shipitURI("/v1/shipment/{shipment}/envVars", param("shipment", shipment))
+	if err != nil {
+		return err
+	}
+	res, body, err := c.create(ctx, uri, envVar)
+	if err != nil {
+		return err
 	}
+	if res.StatusCode != http.StatusCreated {
+		return newResponseError("unable to create shipment envvar", res, body)
+	}
+
+	return nil
+}
 
-	res, body, err := gorequest.New().
-		Post(url).
-		Set("x-username", username).
-		Set("x-token", token).
-		Send(data).
-		End()
+// UpdateShipmentEnvVar updates a shipment-level environment variable
+func (c *HarborClient) UpdateShipmentEnvVar(ctx context.Context, shipment string, name string, envVar EnvVarPayload) error {
 
+	uri, err := c.shipitURI("/v1/shipment/{shipment}/envVar/{envVar}",
+		param("shipment", shipment),
+		param("envVar", name))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if Verbose {
-		log.Printf("status code = %v", res.StatusCode)
-		log.Println(body)
+	res, body, err := c.update(ctx, uri, envVar)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return newResponseError("shipment envvar update failed", res, body)
 	}
 
-	return res, body, err
+	return nil
 }
 
-func update(username string, token string, url string, data interface{}) (*http.Response, string, []error) {
+// GetShipmentEnvironment returns a harbor shipment/environment from the API (nil if it doesn't exist)
+func (c *HarborClient) GetShipmentEnvironment(ctx context.Context, shipment string, env string) (*ShipmentEnvironment, error) {
 
-	if Verbose {
-		log.Printf("PUT %v", url)
-		if b, e := json.Marshal(data); e == nil {
-			log.Println(string(b))
-		}
+	//build URI
+	uri, err := c.shipitURI("/v1/shipment/{shipment}/environment/{env}/",
+		param("shipment", shipment),
+		param("env", env))
+	if err != nil {
+		return nil, err
+	}
+
+	//issue request
+	resp, body, err := c.get(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	//return nil if the shipment/env isn't found
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newResponseError("GetShipmentEnvironment", resp, body)
+	}
+
+	//deserialize json into object
+	var result ShipmentEnvironment
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
 	}
 
-	res, body, err := gorequest.New().
-		Put(url).
-		Set("x-username", username).
-		Set("x-token", token).
-		Send(data).
-		End()
+	//sort data so we can ensure consistent order when writing/reading
+	sortData(&result)
 
+	return &result, nil
+}
+
+//UpdateProvider updates provider configuration
+func (c *HarborClient) UpdateProvider(ctx context.Context, shipment string, env string, provider ProviderPayload) error {
+
+	uri, err := c.shipitURI("/v1/shipment/{shipment}/environment/{env}/provider/ec2",
+		param("shipment", shipment),
+		param("env", env))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if Verbose {
-		log.Println(body)
-		log.Printf("status code = %v", res.StatusCode)
+	logDebug("updating replicas on shipment provider: %v", uri)
+
+	//call the API
+	r, body, err := c.update(ctx, uri, provider)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		return newResponseError("update provider failed", r, body)
 	}
 
-	return res, body, err
+	return nil
 }
 
-func deleteHTTP(username string, token string, url string) (*http.Response, string, []error) {
+//UpdateShipmentEnvironment updates shipment/environment-level configuration
+func (c *HarborClient) UpdateShipmentEnvironment(ctx context.Context, shipment string, composeShipment ComposeShipment) error {
 
-	if Verbose {
-		log.Printf("DELETE %v", url)
+	//update enableMonitoring
+	request := UpdateShipmentEnvironmentRequest{
+		EnableMonitoring: *composeShipment.EnableMonitoring,
 	}
 
-	res, body, err := gorequest.New().
-		Delete(url).
-		Set("x-username", username).
-		Set("x-token", token).
-		End()
+	return c.UpdateShipmentEnvironmentSettings(ctx, shipment, composeShipment.Env, request)
+}
 
+//UpdateShipmentEnvironmentSettings updates environment-level settings (enableMonitoring, iamRole)
+func (c *HarborClient) UpdateShipmentEnvironmentSettings(ctx context.Context, shipment string, env string, request UpdateShipmentEnvironmentRequest) error {
+
+	uri, err := c.shipitURI("/v1/shipment/{shipment}/environment/{env}",
+		param("shipment", shipment),
+		param("env", env))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	if Verbose {
-		log.Printf("status code = %v", res.StatusCode)
-		log.Println(body)
+	logDebug("updating environment settings: %v", uri)
+
+	//call the API
+	r, body, err := c.update(ctx, uri, request)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		return newResponseError("update shipment environment failed", r, body)
 	}
 
-	return res, body, err
+	return nil
 }
 
 // GetLogs returns a string of all container logs for a shipment
-func GetLogs(barge string, shipment string, env string) string {
+func (c *HarborClient) GetLogs(ctx context.Context, barge string, shipment string, env string) (string, error) {
 
-	uri := helmitURI("/harbor/{barge}/{shipment}/{env}",
+	uri, err := c.helmitURI("/harbor/{barge}/{shipment}/{env}",
 		param("barge", barge),
 		param("shipment", shipment),
 		param("env", env))
-
-	_, body, err := gorequest.New().
-		Get(uri).
-		End()
-
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
-	if Verbose {
-		fmt.Println(uri)
-		fmt.Println("Fetching Harbor Logs")
+	logDebug("fetching harbor logs: %v", uri)
+
+	_, body, err := c.send(ctx, http.MethodGet, uri, nil, nil)
+	if err != nil {
+		return "", err
 	}
 
-	return body
+	return string(body), nil
 }
 
 // GetLogStreamer return reader object to parse docker container logs
-func GetLogStreamer(streamer string) (reader *bufio.Reader, err error) {
-	resp, err := http.Get(streamer)
+func (c *HarborClient) GetLogStreamer(ctx context.Context, streamer string) (*bufio.Reader, error) {
+	req, err := http.NewRequest(http.MethodGet, streamer, nil)
+	if err != nil {
+		return nil, err
+	}
 
+	resp, err := c.http.Do(req.WithContext(ctx))
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	reader = bufio.NewReader(resp.Body)
-	return
+	return bufio.NewReader(resp.Body), nil
 }
 
 // GetShipmentStatus returns the running status of a shipment
-func GetShipmentStatus(barge string, shipment string, env string) *ShipmentStatus {
+func (c *HarborClient) GetShipmentStatus(ctx context.Context, barge string, shipment string, env string) (*ShipmentStatus, error) {
 
-	uri := helmitURI("/shipment/status/{barge}/{shipment}/{env}",
+	uri, err := c.helmitURI("/shipment/status/{barge}/{shipment}/{env}",
 		param("barge", barge),
 		param("shipment", shipment),
 		param("env", env))
-
-	if Verbose {
-		fmt.Println("fetching: " + uri)
+	if err != nil {
+		return nil, err
 	}
 
-	res, body, err := gorequest.New().
-		Get(uri).
-		EndBytes()
+	logDebug("fetching: %v", uri)
 
+	res, body, err := c.send(ctx, http.MethodGet, uri, nil, nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	if res.StatusCode != http.StatusOK {
-		log.Fatal("GetShipmentStatus returned ", res.StatusCode)
+		return nil, newResponseError("GetShipmentStatus", res, body)
 	}
 
 	//deserialize json into object
 	var result ShipmentStatus
-	unmarshalErr := json.Unmarshal(body, &result)
-	if unmarshalErr != nil {
-		log.Fatal(unmarshalErr)
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, err
 	}
 
-	return &result
+	return &result, nil
 }
 
-// Trigger calls the trigger api
-func Trigger(shipment string, env string) (bool, []string) {
+// Trigger calls the trigger api and returns its messages
+func (c *HarborClient) Trigger(ctx context.Context, shipment string, env string) ([]string, error) {
 
 	//build URI
-	uri := triggerURI("/{shipment}/{env}/ec2",
+	uri, err := c.triggerURI("/{shipment}/{env}/ec2",
 		param("shipment", shipment),
 		param("env", env))
-
-	if Verbose {
-		log.Printf("triggering shipment: " + uri)
+	if err != nil {
+		return nil, err
 	}
 
-	//make network request
-	resp, body, err := gorequest.New().
-		Post(uri).
-		EndBytes()
+	logInfo("triggering shipment: %v", uri)
 
-	//handle errors
+	//make network request
+	resp, body, err := c.send(ctx, http.MethodPost, uri, nil, nil)
 	if err != nil {
-		log.Println("an error occurred calling trigger api")
-		log.Fatal(err)
+		return nil, fmt.Errorf("an error occurred calling trigger api: %v", err)
 	}
 
-	//if verbose or non-OK, log status code and message body
-	if Verbose || resp.StatusCode != http.StatusOK {
-		log.Printf("trigger api returned a %v", resp.StatusCode)
-		log.Println(string(body))
+	//log status code and message body (as a warning if non-OK)
+	if resp.StatusCode != http.StatusOK {
+		logWarn("trigger api returned a %v: %v", resp.StatusCode, redactBody(body))
+	} else {
+		logDebug("trigger api returned a %v: %v", resp.StatusCode, redactBody(body))
 	}
 
 	//trigger api returns both single and multiple messages:
@@ -360,183 +554,167 @@ func Trigger(shipment string, env string) (bool, []string) {
 	if strings.Contains(string(body), "message\":\"") {
 		//convert single message into an array for consistency
 		var response TriggerResponseSingle
-		unmarshalErr := json.Unmarshal(body, &response)
-		if unmarshalErr != nil {
-			log.Fatal(unmarshalErr)
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			return nil, err
 		}
 		result = append(result, response.Message)
 	} else if strings.Contains(string(body), "message\":[") {
 		//multiple messages
 		var response TriggerResponseMultiple
-		unmarshalErr := json.Unmarshal(body, &response)
-		if unmarshalErr != nil {
-			log.Fatal(unmarshalErr)
+		err = json.Unmarshal(body, &response)
+		if err != nil {
+			return nil, err
 		}
 		result = response.Messages
 	}
 
-	//return whether trigger call was successful along with messages
-	return resp.StatusCode == http.StatusOK, result
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("trigger failed: %v", strings.Join(result, "\n"))
+	}
+
+	return result, nil
 }
 
-func getLoadBalancerStatus(shipment string, env string) (*LoadBalancer, error) {
+func (c *HarborClient) getLoadBalancerStatus(ctx context.Context, shipment string, env string) (*LoadBalancer, error) {
 
-	uri := triggerURI("/v2/loadbalancer/status/{shipment}/{env}/{provider}",
+	uri, err := c.triggerURI("/v2/loadbalancer/status/{shipment}/{env}/{provider}",
 		param("shipment", shipment),
 		param("env", env),
 		param("provider", providerEc2))
-
-	if Verbose {
-		log.Printf("getting lb status: " + uri)
+	if err != nil {
+		return nil, err
 	}
 
+	logDebug("getting lb status: %v", uri)
+
 	//issue request
-	res, body, err := gorequest.New().Get(uri).EndBytes()
+	res, body, err := c.send(ctx, http.MethodGet, uri, nil, nil)
 	if err != nil {
-		return nil, err[0]
+		return nil, err
 	}
 
 	var result LoadBalancer
 	if res.StatusCode == http.StatusOK {
-		if Verbose {
-			log.Println(string(body))
-		}
+		logTrace("lb status: %v", redactBody(body))
 
 		unmarshalErr := json.Unmarshal(body, &result)
 		if unmarshalErr != nil {
 			return nil, unmarshalErr
 		}
 	} else {
-		return nil, fmt.Errorf("get lb status returned: %v; %v", res.StatusCode, string(body))
+		return nil, newResponseError("get lb status", res, body)
 	}
 
 	return &result, nil
 }
 
 // SaveEnvVar updates an environment variable in harbor (supports both environment and container levels)
-func SaveEnvVar(username string, token string, shipment string, composeShipment ComposeShipment, envVarPayload EnvVarPayload, container string) {
-	var config = GetConfig()
+func (c *HarborClient) SaveEnvVar(ctx context.Context, shipment string, composeShipment ComposeShipment, envVarPayload EnvVarPayload, container string) error {
 
 	//first, issue a GET to check if the var exists
 	//if not exists, issue a POST
 	//if exists and value has changed, issue a PUT
 
-	//build url
-	templateStringEnvLevelExisting := config.ShipitURI + "/v1/shipment/{shipment}/environment/{env}/envvar/{envvar}"
-	templateStringContainerLevelExisting := config.ShipitURI + "/v1/shipment/{shipment}/environment/{env}/container/{container}/envvar/{envvar}"
-	templateStringEnvLevelNew := config.ShipitURI + "/v1/shipment/{shipment}/environment/{env}/envvars/"
-	templateStringContainerLevelNew := config.ShipitURI + "/v1/shipment/{shipment}/environment/{env}/container/{container}/envvars/"
-
-	values := make(map[string]interface{})
-	values["shipment"] = shipment
-	values["env"] = composeShipment.Env
-	values["envvar"] = envVarPayload.Name
-	values["container"] = container
-
 	//is the var at the environment or container level?
-	templateString := templateStringEnvLevelExisting
+	templateExisting := "/v1/shipment/{shipment}/environment/{env}/envvar/{envvar}"
+	templateNew := "/v1/shipment/{shipment}/environment/{env}/envvars/"
 	if len(container) > 0 {
-		templateString = templateStringContainerLevelExisting
+		templateExisting = "/v1/shipment/{shipment}/environment/{env}/container/{container}/envvar/{envvar}"
+		templateNew = "/v1/shipment/{shipment}/environment/{env}/container/{container}/envvars/"
 	}
-	template, _ := uritemplates.Parse(templateString)
-	uri, _ := template.Expand(values)
 
-	//issue GET request
-	request := gorequest.New().Get(uri).
-		Set("x-username", username).
-		Set("x-token", token)
+	params := []tuple{
+		param("shipment", shipment),
+		param("env", composeShipment.Env),
+		param("envvar", envVarPayload.Name),
+		param("container", container),
+	}
 
-	if Verbose {
-		fmt.Println("fetching: " + uri)
+	//issue GET request
+	uri, err := c.shipitURI(templateExisting, params...)
+	if err != nil {
+		return err
 	}
-	res, body, err := request.EndBytes()
+	res, body, err := c.get(ctx, uri)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	//exist?
 	if res.StatusCode == http.StatusNotFound { //not exist
-		//build url
-		//now POST a new envvar
-		//is the var at the environment or container level?
-		templateString := templateStringEnvLevelNew
-		if len(container) > 0 {
-			templateString = templateStringContainerLevelNew
-		}
-		template, _ = uritemplates.Parse(templateString)
-		uri, _ = template.Expand(values)
 
-		if Verbose {
-			fmt.Println("creating env var...")
-		}
+		//now POST a new envvar
+		logDebug("creating env var %v", envVarPayload.Name)
 
 		//call the api
-		r, _, e := create(username, token, uri, envVarPayload)
-		if e != nil {
-			check(e[0])
+		uri, err := c.shipitURI(templateNew, params...)
+		if err != nil {
+			return err
+		}
+		r, body, err := c.create(ctx, uri, envVarPayload)
+		if err != nil {
+			return err
 		}
 		if r.StatusCode != http.StatusCreated {
-			check(errors.New("unable to create env var"))
+			return newResponseError("unable to create env var", r, body)
 		}
 
-	} else { //exist
-		//issue PUT if modified
+		return nil
+	}
 
-		//deserialize json into object
-		var result EnvVarPayload
-		unmarshalErr := json.Unmarshal(body, &result)
-		check(unmarshalErr)
-
-		//modified?
-		if result.Value != envVarPayload.Value || result.Type != envVarPayload.Type {
-			if Verbose {
-				fmt.Println("updating env var...")
-			}
-
-			//is the var at the environment or container level?
-			templateString := templateStringEnvLevelExisting
-			if len(container) > 0 {
-				templateString = templateStringContainerLevelExisting
-			}
-			template, _ = uritemplates.Parse(templateString)
-			uri, _ = template.Expand(values)
-			r, _, e := update(username, token, uri, envVarPayload)
-			if e != nil {
-				check(e[0])
-			}
-			if r.StatusCode != http.StatusOK {
-				check(errors.New("unable to update env var"))
-			}
+	//exist, issue PUT if modified
 
-		} else {
-			if Verbose {
-				fmt.Println("envvar unchanged, skipping")
-			}
-		}
+	//deserialize json into object
+	var result EnvVarPayload
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return err
+	}
+
+	//modified?
+	if result.Value == envVarPayload.Value && result.Type == envVarPayload.Type {
+		logDebug("envvar %v unchanged, skipping", envVarPayload.Name)
+		return nil
+	}
+
+	logDebug("updating env var %v", envVarPayload.Name)
+
+	r, body, err := c.update(ctx, uri, envVarPayload)
+	if err != nil {
+		return err
+	}
+	if r.StatusCode != http.StatusOK {
+		return newResponseError("unable to update env var", r, body)
 	}
+
+	return nil
 }
 
 // UpdateContainerImage updates a container version on a shipment
-func UpdateContainerImage(username string, token string, shipment string, env string, container ContainerPayload) {
+func (c *HarborClient) UpdateContainerImage(ctx context.Context, shipment string, env string, container ContainerPayload) error {
 
 	//build url
-	uri := shipitURI("/v1/shipment/{shipment}/environment/{env}/container/{container}",
+	uri, err := c.shipitURI("/v1/shipment/{shipment}/environment/{env}/container/{container}",
 		param("shipment", shipment),
 		param("env", env),
 		param("container", container.Name))
-
-	if Verbose {
-		log.Printf("updating container settings")
+	if err != nil {
+		return err
 	}
 
+	logDebug("updating container settings: %v", uri)
+
 	//call api
-	r, _, err := update(username, token, uri, container)
+	r, body, err := c.update(ctx, uri, container)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	if r.StatusCode != http.StatusOK {
-		check(errors.New("update failed"))
+		return newResponseError("update container failed", r, body)
 	}
+
+	return nil
 }
 
 func sortData(shipmentEnv *ShipmentEnvironment) {
@@ -565,217 +743,215 @@ func sortData(shipmentEnv *ShipmentEnvironment) {
 	})
 }
 
+//credentialsCarrier is implemented by payloads that include the caller's credentials
+type credentialsCarrier interface {
+	setCredentials(username string, token string)
+}
+
+func (s *ShipmentEnvironment) setCredentials(username string, token string) {
+	s.Username = username
+	s.Token = token
+}
+
 // SaveShipmentEnvironment bulk saves a new shipment/environment
 //and returns the build token
-func SaveShipmentEnvironment(username string, token string, shipment ShipmentEnvironment) (bool, string) {
-
-	var config = GetConfig()
-	shipment.Username = username
-	shipment.Token = token
+func (c *HarborClient) SaveShipmentEnvironment(ctx context.Context, shipment ShipmentEnvironment) (string, error) {
 
 	//sort data so we can ensure consistent order when writing/reading
 	sortData(&shipment)
 
 	//POST /api/v1/shipments
-	res, body, err := create(username, token, config.ShipitURI+"/v1/bulk/shipments", shipment)
-
-	if err != nil || !(res.StatusCode == http.StatusCreated || res.StatusCode == http.StatusOK) {
-		fmt.Printf("creating shipment was not successful: %v\ncode: %v\n", body, res.StatusCode)
-		return false, ""
+	res, body, err := c.create(ctx, c.config.ShipitURI+"/v1/bulk/shipments", &shipment)
+	if err != nil {
+		return "", err
 	}
 
-	success := true
-	buildToken := ""
+	if !(res.StatusCode == http.StatusCreated || res.StatusCode == http.StatusOK) {
+		return "", newResponseError("creating shipment was not successful", res, body)
+	}
 
 	//api returns an object with an errors property that is
 	//false when there are no errors and an object if there are
-	if strings.Contains(body, "errors\": {") {
-		success = false
-		if Verbose {
-			log.Println(body)
-		}
+	if fields := parseValidationErrors(body); len(fields) > 0 {
+		return "", &ValidationError{Op: "creating shipment was not successful", Fields: fields}
 	}
 
-	if success {
-		//deserialize response to get the outputted build token
-		var newShipment ShipmentEnvironment
-		unmarshalErr := json.Unmarshal([]byte(body), &newShipment)
-		if unmarshalErr != nil {
-			log.Fatal(unmarshalErr)
-		}
-		buildToken = newShipment.BuildToken
+	//deserialize response to get the outputted build token
+	var newShipment ShipmentEnvironment
+	err = json.Unmarshal(body, &newShipment)
+	if err != nil {
+		return "", err
 	}
 
-	return success, buildToken
+	return newShipment.BuildToken, nil
 }
 
 // DeleteShipmentEnvironment deletes a shipment/environment from harbor
-func DeleteShipmentEnvironment(username string, token string, shipment string, env string) {
+func (c *HarborClient) DeleteShipmentEnvironment(ctx context.Context, shipment string, env string) error {
 
 	//build URI
-	uri := shipitURI("/v1/shipment/{shipment}/environment/{env}",
+	uri, err := c.shipitURI("/v1/shipment/{shipment}/environment/{env}",
 		param("shipment", shipment),
 		param("env", env))
-
-	if Verbose {
-		log.Printf("deleting: " + uri)
+	if err != nil {
+		return err
 	}
 
-	res, _, _ := deleteHTTP(username, token, uri)
+	logInfo("deleting: %v", uri)
+
+	res, body, err := c.deleteHTTP(ctx, uri)
+	if err != nil {
+		return err
+	}
 
 	if res.StatusCode != http.StatusOK {
-		log.Fatalf("delete returned a status code of %v", res.StatusCode)
+		return newResponseError("delete shipment environment failed", res, body)
 	}
+
+	return nil
 }
 
 // Catalogit sends a POST to the catalogit api
-func Catalogit(container CatalogitContainer) (string, []error) {
-
-	var config = GetConfig()
+func (c *HarborClient) Catalogit(ctx context.Context, container CatalogitContainer) (string, error) {
 
-	if Verbose {
-		log.Printf("Sending POST to: %v /v1/containers\n", config.CatalogitURI)
-	}
+	logDebug("Sending POST to: %v/v1/containers", c.config.CatalogitURI)
 
 	//make network request
-	resp, body, err := gorequest.New().
-		Post(config.CatalogitURI + "/v1/containers").
-		Send(container).
-		EndBytes()
+	resp, body, err := c.send(ctx, http.MethodPost, c.config.CatalogitURI+"/v1/containers", nil, container)
+	if err != nil {
+		return "", err
+	}
 
 	//treat non-OK as error
 	if resp.StatusCode != http.StatusOK {
-		err = append(err, fmt.Errorf("catalogit api returned a %v", resp.StatusCode))
+		return string(body), newResponseError("catalogit", resp, body)
 	}
 
-	return string(body), err
+	return string(body), nil
 }
 
 //IsContainerVersionCataloged determines whether or not a container/version exists in the catalog
-func IsContainerVersionCataloged(name string, version string) bool {
+func (c *HarborClient) IsContainerVersionCataloged(ctx context.Context, name string, version string) (bool, error) {
 
 	//build URI
-	uri := customsURI("/catalog/{name}/{version}/",
+	uri, err := c.customsURI("/catalog/{name}/{version}/",
 		param("name", name),
 		param("version", version))
-
-	if Verbose {
-		log.Println("fetching: " + uri)
+	if err != nil {
+		return false, err
 	}
 
-	//issue request
-	res, _, err := gorequest.New().Get(uri).EndBytes()
+	logDebug("fetching: %v", uri)
 
+	//issue request
+	res, body, err := c.send(ctx, http.MethodGet, uri, nil, nil)
 	if err != nil {
-		log.Fatal(err)
+		return false, err
 	}
 
 	//not found
-	if res.StatusCode == 404 {
-		return false
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
 	}
 
 	//throw error if not OK
 	if res.StatusCode != http.StatusOK {
-		log.Fatalf("GET %v returned %v", uri, res.StatusCode)
+		return false, newResponseError("GET "+uri, res, body)
 	}
 
-	return true
+	return true, nil
 }
 
 // Deploy deploys (and catalogs) a shipment container to an environment
-func Deploy(shipment string, env string, buildToken string, deployRequest DeployRequest, provider string) {
+func (c *HarborClient) Deploy(ctx context.Context, shipment string, env string, buildToken string, deployRequest DeployRequest, provider string) error {
 
 	//build URI
-	uri := customsURI("/deploy/{shipment}/{env}/{provider}",
+	uri, err := c.customsURI("/deploy/{shipment}/{env}/{provider}",
 		param("shipment", shipment),
 		param("env", env),
 		param("provider", provider))
-
-	request := gorequest.New().Get(uri)
-
-	if Verbose {
-		log.Printf("POST " + uri)
+	if err != nil {
+		return err
 	}
 
-	//make network request
-	res, body, err := request.
-		Post(uri).
-		Set("x-build-token", buildToken).
-		Send(deployRequest).
-		EndBytes()
+	logDebug("POST %v", uri)
 
-	//handle errors
+	//make network request
+	headers := map[string]string{"x-build-token": buildToken}
+	res, body, err := c.send(ctx, http.MethodPost, uri, headers, deployRequest)
 	if err != nil {
-		log.Println("an error occurred calling customs api")
-		log.Fatal(err)
+		return fmt.Errorf("an error occurred calling customs api: %v", err)
 	}
 
 	//logging
-	if Verbose || res.StatusCode != http.StatusOK {
-		log.Printf("customs api returned a %v", res.StatusCode)
-		log.Println(string(body))
+	if res.StatusCode != http.StatusOK {
+		logWarn("customs api returned a %v: %v", res.StatusCode, redactBody(body))
+	} else {
+		logDebug("customs api returned a %v", res.StatusCode)
 	}
 
 	if res.StatusCode != http.StatusOK {
-		log.Fatal("customs/deploy failed")
+		return newResponseError("customs/deploy failed", res, body)
 	}
+
+	return nil
 }
 
 // CatalogCustoms catalogs a container using the customs catalog api
-func CatalogCustoms(shipment string, env string, buildToken string, catalogRequest CatalogitContainer, provider string) {
+func (c *HarborClient) CatalogCustoms(ctx context.Context, shipment string, env string, buildToken string, catalogRequest CatalogitContainer, provider string) error {
 
-	uri := customsURI("/catalog/{shipment}/{env}/{provider}",
+	uri, err := c.customsURI("/catalog/{shipment}/{env}/{provider}",
 		param("shipment", shipment),
 		param("env", env),
 		param("provider", provider))
-
-	request := gorequest.New().Get(uri)
-
-	if Verbose {
-		log.Printf("POST " + uri)
+	if err != nil {
+		return err
 	}
 
-	//make network request
-	res, body, err := request.
-		Post(uri).
-		Set("x-build-token", buildToken).
-		Send(catalogRequest).
-		EndBytes()
+	logDebug("POST %v", uri)
 
-	//handle errors
+	//make network request
+	headers := map[string]string{"x-build-token": buildToken}
+	res, body, err := c.send(ctx, http.MethodPost, uri, headers, catalogRequest)
 	if err != nil {
-		log.Println("an error occurred calling customs api")
-		log.Fatal(err)
+		return fmt.Errorf("an error occurred calling customs api: %v", err)
 	}
 
 	//logging
-	if Verbose || res.StatusCode != http.StatusOK {
-		log.Printf("customs api returned a %v", res.StatusCode)
-		log.Println(string(body))
+	if res.StatusCode != http.StatusOK {
+		logWarn("customs api returned a %v: %v", res.StatusCode, redactBody(body))
+	} else {
+		logDebug("customs api returned a %v", res.StatusCode)
 	}
 
 	if res.StatusCode != http.StatusOK {
-		log.Fatal("customs/catalog failed")
+		return newResponseError("customs/catalog failed", res, body)
 	}
+
+	return nil
 }
 
 //update a port
-func updatePort(username string, token string, shipment string, env string, container string, port UpdatePortRequest) {
+func (c *HarborClient) updatePort(ctx context.Context, shipment string, env string, container string, port UpdatePortRequest) error {
 
 	//build url
-	uri := shipitURI("/v1/shipment/{shipment}/environment/{env}/container/{container}/port/{port}",
+	uri, err := c.shipitURI("/v1/shipment/{shipment}/environment/{env}/container/{container}/port/{port}",
 		param("shipment", shipment),
 		param("env", env),
 		param("container", container),
 		param("port", port.Name))
+	if err != nil {
+		return err
+	}
 
 	//make the api call
-	r, _, e := update(username, token, uri, port)
-	if e != nil {
-		log.Fatal(e)
+	r, body, err := c.update(ctx, uri, port)
+	if err != nil {
+		return err
 	}
 	if r.StatusCode != http.StatusOK {
-		check(errors.New("update port failed"))
+		return newResponseError("update port failed", r, body)
 	}
+
+	return nil
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// NotFoundError is returned when a harbor resource doesn't exist
+type NotFoundError struct {
+	Op string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%v: not found", e.Op)
+}
+
+// UnauthorizedError is returned when harbor rejects the caller's credentials
+type UnauthorizedError struct {
+	Op      string
+	Message string
+}
+
+func (e *UnauthorizedError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%v: %v", e.Op, e.Message)
+	}
+	return fmt.Sprintf("%v: unauthorized. Please run harbor-compose login", e.Op)
+}
+
+// ConflictError is returned when a harbor resource already exists or was modified concurrently
+type ConflictError struct {
+	Op   string
+	Body string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%v: conflict: %v", e.Op, e.Body)
+}
+
+// ValidationError is returned when shipit rejects a payload, with a message per field
+type ValidationError struct {
+	Op     string
+	Fields map[string]string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: validation failed:\n%v", e.Op, e.FieldMessages())
+}
+
+// FieldMessages returns one "field: message" line per field, sorted by field
+func (e *ValidationError) FieldMessages() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field := range e.Fields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	lines := make([]string, len(fields))
+	for i, field := range fields {
+		lines[i] = fmt.Sprintf("  %v: %v", field, e.Fields[field])
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ServerError is returned for any other unexpected response
+type ServerError struct {
+	Op         string
+	StatusCode int
+	Body       string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("%v: status code = %v; %v", e.Op, e.StatusCode, e.Body)
+}
+
+//newResponseError translates an unexpected harbor api response into a typed error.
+//response bodies are redacted since errors are shown by terraform (and written to its log)
+func newResponseError(op string, res *http.Response, body []byte) error {
+	switch res.StatusCode {
+
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return &UnauthorizedError{Op: op}
+
+	case http.StatusNotFound:
+		return &NotFoundError{Op: op}
+
+	case http.StatusConflict:
+		return &ConflictError{Op: op, Body: redactBody(body)}
+
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		if fields := parseValidationErrors(body); len(fields) > 0 {
+			return &ValidationError{Op: op, Fields: fields}
+		}
+
+		//some endpoints only return a message
+		var response struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &response) == nil && response.Message != "" {
+			return &ValidationError{Op: op, Fields: map[string]string{"message": response.Message}}
+		}
+	}
+
+	return &ServerError{Op: op, StatusCode: res.StatusCode, Body: redactBody(body)}
+}
+
+//parseValidationErrors returns the per-field messages from a shipit response.
+//shipit returns an errors property that is false when there are no errors
+//and an object (possibly nested) when there are, e.g.:
+//{"errors": {"containers": {"my-app": {"image": "image is required"}}}}
+func parseValidationErrors(body []byte) map[string]string {
+	fields := make(map[string]string)
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fields
+	}
+
+	if errs, ok := response["errors"]; ok {
+		flattenValidationErrors("", errs, fields)
+
+		//errors that aren't specific to a field
+		if msg, ok := fields[""]; ok {
+			delete(fields, "")
+			fields["message"] = msg
+		}
+	}
+
+	return fields
+}
+
+func flattenValidationErrors(path string, value interface{}, fields map[string]string) {
+	switch v := value.(type) {
+
+	case map[string]interface{}:
+		for key, child := range v {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			flattenValidationErrors(childPath, child, fields)
+		}
+
+	case []interface{}:
+		for i, child := range v {
+			//a list of messages for the same field
+			if msg, ok := child.(string); ok {
+				if fields[path] != "" {
+					msg = fields[path] + "; " + msg
+				}
+				fields[path] = msg
+				continue
+			}
+			flattenValidationErrors(fmt.Sprintf("%v[%v]", path, i), child, fields)
+		}
+
+	case string:
+		fields[path] = v
+
+	case bool:
+		//errors: false means no errors
+		if v {
+			fields[path] = "invalid"
+		}
+
+	case nil:
+
+	default:
+		fields[path] = fmt.Sprint(v)
+	}
+}
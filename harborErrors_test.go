@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseValidationErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		body   string
+		fields map[string]string
+	}{
+		{
+			name: "nested",
+			body: `{"errors": {"containers": {"web": {"image": "is required", "ports": {"http": {"healthcheck": "must start with /"}}}}}}`,
+			fields: map[string]string{
+				"containers.web.image":                  "is required",
+				"containers.web.ports.http.healthcheck": "must start with /",
+			},
+		},
+		{
+			name: "list of messages",
+			body: `{"errors": {"name": ["is too long", "must be lowercase"]}}`,
+			fields: map[string]string{
+				"name": "is too long; must be lowercase",
+			},
+		},
+		{
+			name: "list of objects",
+			body: `{"errors": {"containers": [{"name": "is required"}, {"image": "is invalid"}]}}`,
+			fields: map[string]string{
+				"containers[0].name":  "is required",
+				"containers[1].image": "is invalid",
+			},
+		},
+		{
+			name: "top level list",
+			body: `{"errors": ["shipment is locked"]}`,
+			fields: map[string]string{
+				"message": "shipment is locked",
+			},
+		},
+		{
+			name: "flagged field",
+			body: `{"errors": {"replicas": true, "barge": false}}`,
+			fields: map[string]string{
+				"replicas": "invalid",
+			},
+		},
+		{
+			name:   "errors false",
+			body:   `{"errors": false, "message": "ok"}`,
+			fields: map[string]string{},
+		},
+		{
+			name:   "no errors",
+			body:   `{"message": "bad request"}`,
+			fields: map[string]string{},
+		},
+		{
+			name:   "not json",
+			body:   `<html>bad gateway</html>`,
+			fields: map[string]string{},
+		},
+	}
+
+	for _, c := range cases {
+		fields := parseValidationErrors([]byte(c.body))
+		if !reflect.DeepEqual(fields, c.fields) {
+			t.Errorf("%v: expected %v, got %v", c.name, c.fields, fields)
+		}
+	}
+}
+
+func TestValidationResponseError(t *testing.T) {
+	res := &http.Response{StatusCode: http.StatusUnprocessableEntity}
+
+	err := newResponseError("save", res, []byte(`{"errors": {"replicas": "must be positive"}}`))
+	if _, ok := err.(*ValidationError); !ok {
+		t.Fatalf("expected a ValidationError, got %T", err)
+	}
+
+	//without field errors, the message is used
+	err = newResponseError("save", res, []byte(`{"errors": false, "message": "environment is locked"}`))
+	verr, ok := err.(*ValidationError)
+	if !ok || verr.Fields["message"] != "environment is locked" {
+		t.Fatalf("expected the message as a ValidationError, got %#v", err)
+	}
+
+	//nothing to report, so the body is returned as is
+	err = newResponseError("save", res, []byte(`{"errors": false}`))
+	if _, ok := err.(*ServerError); !ok {
+		t.Fatalf("expected a ServerError, got %T", err)
+	}
+}
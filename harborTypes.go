@@ -172,7 +172,8 @@ type DeployRequest struct {
 
 // UpdateShipmentEnvironmentRequest represents a request to update a shipment/environment
 type UpdateShipmentEnvironmentRequest struct {
-	EnableMonitoring bool `json:"enableMonitoring"`
+	EnableMonitoring bool    `json:"enableMonitoring"`
+	IamRole          *string `json:"iamRole,omitempty"`
 }
 
 // UpdatePortRequest represents a request to update a port
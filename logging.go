@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	redacted         = "**REDACTED**"
+	envVarTypeHidden = "hidden"
+
+	//secrets shorter than this aren't scrubbed from log lines (too likely to mangle unrelated text)
+	minSecretLength = 6
+)
+
+//json properties whose values are never logged
+var secretFields = map[string]bool{
+	"token":          true,
+	"password":       true,
+	"buildToken":     true,
+	"private_key":    true,
+	"aws_secret_key": true,
+	"aws_access_key": true,
+	"x-token":        true,
+	"x-build-token":  true,
+}
+
+//env vars whose values are never logged (in addition to env vars of type hidden)
+var secretEnvVars = map[string]bool{
+	envVarNameAccessKey: true,
+	envVarNameSecretKey: true,
+}
+
+//known secret values (e.g., the user's token) that are scrubbed from every log line
+var secrets = struct {
+	sync.RWMutex
+	values map[string]bool
+}{values: make(map[string]bool)}
+
+//registerSecret ensures that a secret value is never written to the log
+func registerSecret(value string) {
+	if len(value) < minSecretLength {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values[value] = true
+}
+
+//scrubSecrets replaces any registered secret values in s
+func scrubSecrets(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for value := range secrets.values {
+		s = strings.Replace(s, value, redacted, -1)
+	}
+	return s
+}
+
+//leveled logging. terraform filters plugin log lines by the [LEVEL] prefix
+//according to TF_LOG (TRACE, DEBUG, INFO, WARN, ERROR)
+
+func logTrace(format string, v ...interface{}) {
+	logLevel("TRACE", format, v...)
+}
+
+func logDebug(format string, v ...interface{}) {
+	logLevel("DEBUG", format, v...)
+}
+
+func logInfo(format string, v ...interface{}) {
+	logLevel("INFO", format, v...)
+}
+
+func logWarn(format string, v ...interface{}) {
+	logLevel("WARN", format, v...)
+}
+
+func logError(format string, v ...interface{}) {
+	logLevel("ERROR", format, v...)
+}
+
+func logLevel(level string, format string, v ...interface{}) {
+	log.Printf("[%v] %v", level, fmt.Sprintf(format, v...))
+}
+
+//logSink is where the provider's log is written. It's captured when the plugin starts,
+//before plugin.Serve redirects os.Stderr, so it's the same writer Serve logs to.
+var logSink io.Writer = os.Stderr
+
+//installRedactingLog routes the standard logger through the redacting sink.
+//plugin.Serve replaces the log output, so this runs when the provider is configured.
+//It always wraps logSink, so calling it again (e.g., for provider aliases) doesn't nest writers.
+func installRedactingLog() {
+	log.SetOutput(&redactingWriter{out: logSink})
+}
+
+//redactingWriter is the log sink; it scrubs registered secrets before anything is written
+type redactingWriter struct {
+	out io.Writer
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	_, err := w.out.Write([]byte(scrubSecrets(string(p))))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//redactPayload returns an indented json representation of v (e.g., a ShipmentEnvironment)
+//that is safe to log: secret properties, log shipping keys and hidden env vars are redacted
+func redactPayload(v interface{}) string {
+	b, err := json.MarshalIndent(v, "\t", "\t")
+	if err != nil {
+		return ""
+	}
+	return redactBody(b)
+}
+
+//redactBody returns a json request/response body that is safe to log
+func redactBody(body []byte) string {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		//not json, rely on the log sink to scrub known secrets
+		return string(body)
+	}
+
+	b, err := json.MarshalIndent(redactValue(doc), "\t", "\t")
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+
+	case map[string]interface{}:
+		for k, child := range t {
+			if secretFields[k] {
+				if s, ok := child.(string); ok && s != "" {
+					t[k] = redacted
+				}
+				continue
+			}
+			t[k] = redactValue(child)
+		}
+
+		//env vars look like {"name": "", "value": "", "type": ""}
+		if name, ok := t["name"].(string); ok {
+			if _, hasValue := t["value"]; hasValue && (secretEnvVars[name] || t["type"] == envVarTypeHidden) {
+				t["value"] = redacted
+			}
+		}
+
+	case []interface{}:
+		for i, child := range t {
+			t[i] = redactValue(child)
+		}
+	}
+
+	return v
+}
+
+//redactHeaders returns http headers, one per line, with auth headers redacted
+func redactHeaders(h http.Header) string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		value := strings.Join(h[name], ", ")
+		if secretFields[strings.ToLower(name)] {
+			value = redacted
+		}
+		lines[i] = fmt.Sprintf("%v: %v", name, value)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -5,9 +5,6 @@ import (
 	"github.com/hashicorp/terraform/terraform"
 )
 
-//Verbose ...
-var Verbose = true
-
 func main() {
 	plugin.Serve(&plugin.ServeOpts{
 		ProviderFunc: func() terraform.ResourceProvider {
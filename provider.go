@@ -1,44 +1,132 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"fmt"
-	"log"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
 	harborauth "github.com/turnerlabs/harbor-auth-client"
 )
 
-var authURI = "https://auth.services.dmtio.net"
-var shipItURI = "http://shipit.services.dmtio.net/v1"
-var infrastructureURI = "http://localhost:8080/api"
-
-func fullyQualifiedURI(id string) string {
-	return fmt.Sprintf("%s/%s", shipItURI, id)
-}
-
-func fullyQualifiedInfrastructureURI(id string) string {
-	return fmt.Sprintf("%s/%s", infrastructureURI, id)
-}
-
 //Auth struct
 type Auth struct {
 	Version  string `json:"version"`
 	Username string `json:"username"`
 	Token    string `json:"token"`
+
+	//used to login again when the token expires
+	password string
+	authURI  string
+	mu       sync.Mutex
 }
 
 // Provider returns a terraform provider
 func Provider() *schema.Provider {
-	return &schema.Provider{
+	provider := &schema.Provider{
 		Schema: map[string]*schema.Schema{
 			"credentials": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
 				Description: "Harbor credentials. Run harbor-compose login to populate.",
 			},
+			"username": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Harbor username. Used along with token or password as an alternative to credentials.",
+			},
+			"token": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Harbor token. Used along with username as an alternative to credentials.",
+			},
+			"password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Harbor password. Used along with username to login and obtain a token in-memory.",
+			},
+			"profile": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_PROFILE", ""),
+				Description: "Named profile in ~/.harbor/config to use for endpoints and credentials.",
+			},
+			"shipit_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_SHIPIT_URL", ""),
+				Description: "ShipIt API endpoint. Defaults to the value in ~/.harbor/config.",
+			},
+			"trigger_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_TRIGGER_URL", ""),
+				Description: "Trigger API endpoint. Defaults to the value in ~/.harbor/config.",
+			},
+			"auth_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_AUTH_URL", ""),
+				Description: "Auth API endpoint. Defaults to the value in ~/.harbor/config.",
+			},
+			"helmit_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_HELMIT_URL", ""),
+				Description: "Helmit API endpoint. Defaults to the value in ~/.harbor/config.",
+			},
+			"customs_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_CUSTOMS_URL", ""),
+				Description: "Customs API endpoint. Defaults to the value in ~/.harbor/config.",
+			},
+			"catalogit_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_CATALOGIT_URL", ""),
+				Description: "CatalogIt API endpoint. Defaults to the value in ~/.harbor/config.",
+			},
+			"request_timeout": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_REQUEST_TIMEOUT", 60),
+				Description: "Timeout (in seconds) for each request to the harbor apis.",
+			},
+			"max_retries": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_MAX_RETRIES", 3),
+				Description: "Maximum number of times a request is retried after a transient failure (network errors, 5xx, 429).",
+			},
+			"retry_max_wait": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_RETRY_MAX_WAIT", 30),
+				Description: "Maximum time (in seconds) to wait between retries.",
+			},
+			"log_http": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_LOG_HTTP", false),
+				Description: "Log full HTTP requests and responses (secrets redacted) at the DEBUG level.",
+			},
+			"snapshot_dir": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_SNAPSHOT_DIR", ""),
+				Description: "Directory where deleted environments are saved so that user images and env vars can be restored when they are re-created. Defaults to ~/.harbor/snapshots.",
+			},
+			"parallelism_per_shipment": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("HARBOR_PARALLELISM_PER_SHIPMENT", 1),
+				Description: "Number of mutating operations (save, trigger, load balancer polling) allowed to run concurrently against the same shipment.",
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"harbor_shipment":     resourceHarborShipment(),
@@ -47,51 +135,119 @@ func Provider() *schema.Provider {
 		DataSourcesMap: map[string]*schema.Resource{
 			"harbor_loadbalancer": dataSourceHarborLoadbalancer(),
 		},
-		ConfigureFunc: providerConfigure,
 	}
-}
-
-func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 
-	//validate harbor token
-	creds := d.Get("credentials").(string)
-	log.Println(creds)
-	if len(creds) == 0 {
-		return nil, errors.New("missing credentials")
+	//pass terraform's stop signal along so that Ctrl-C aborts in-flight requests and polling
+	provider.ConfigureFunc = func(d *schema.ResourceData) (interface{}, error) {
+		return providerConfigure(d, provider.StopContext())
 	}
 
-	//deserialize credentials
-	var auth Auth
-	err := json.Unmarshal([]byte(creds), &auth)
+	return provider
+}
+
+func providerConfigure(d *schema.ResourceData, stopCtx context.Context) (interface{}, error) {
+	//never write secrets (tokens, passwords, keys) to the terraform log
+	installRedactingLog()
+
+	//resolve endpoints once so that provider aliases can target different harbor installations
+	config, err := GetConfig(d.Get("profile").(string))
 	if err != nil {
 		return nil, err
 	}
+	endpoints := map[string]*string{
+		"shipit_url":    &config.ShipitURI,
+		"trigger_url":   &config.TriggerURI,
+		"auth_url":      &config.AuthURI,
+		"helmit_url":    &config.HelmitURI,
+		"customs_url":   &config.CustomsURI,
+		"catalogit_url": &config.CatalogitURI,
+	}
+	for arg, uri := range endpoints {
+		if v := d.Get(arg).(string); v != "" {
+			*uri = strings.TrimSuffix(v, "/")
+		}
+	}
 
-	//validate that credentials are still valid
-	client, err := harborauth.NewAuthClient(authURI)
+	//discover credentials
+	auth, source, err := resolveCredentials(d, config)
 	if err != nil {
 		return nil, err
 	}
+	logDebug("using harbor credentials from %v", source)
+	registerSecret(auth.Token)
+	registerSecret(auth.password)
 
-	success, err := client.IsAuthenticated(auth.Username, auth.Token)
-	if err != nil {
-		if strings.Contains(err.Error(), "401 Unauthorized") {
-			return nil, errors.New("Token has expired. Please run harbor-compose login")
+	//a password was supplied rather than a token, so login to obtain one
+	auth.authURI = config.AuthURI
+	if auth.Token == "" {
+		err = auth.login()
+		if err != nil {
+			return nil, err
 		}
-		return nil, err
+		logDebug("logged in to harbor as %v", auth.Username)
+	} else {
+		//validate that credentials are still valid
+		client, err := harborauth.NewAuthClient(config.AuthURI)
+		if err != nil {
+			return nil, err
+		}
+
+		success, err := client.IsAuthenticated(auth.Username, auth.Token)
+		expired := err != nil && strings.Contains(err.Error(), "401 Unauthorized")
+		if err != nil && !expired {
+			return nil, err
+		}
+
+		//the token is no longer valid, so login again if a password is available
+		if expired || !success {
+			if auth.password == "" {
+				if expired {
+					return nil, errTokenExpired
+				}
+				return nil, errors.New("auth failed")
+			}
+
+			logDebug("harbor token is no longer valid, logging in again as %v", auth.Username)
+			if err = auth.login(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	timeout := time.Duration(d.Get("request_timeout").(int)) * time.Second
+	retry := RetryPolicy{
+		MaxRetries: d.Get("max_retries").(int),
+		MinWait:    defaultRetryMinWait,
+		MaxWait:    time.Duration(d.Get("retry_max_wait").(int)) * time.Second,
 	}
-	if !success {
-		return nil, errors.New("auth failed")
+
+	client := NewHarborClient(config, auth, timeout, retry)
+	client.logHTTP = d.Get("log_http").(bool)
+
+	deletedEnvs, err := newDeletedEnvironments(d.Get("snapshot_dir").(string), config.ShipitURI)
+	if err != nil {
+		return nil, err
 	}
 
 	meta := harborMeta{
-		auth: &auth,
+		client:      client,
+		stopCtx:     stopCtx,
+		deletedEnvs: deletedEnvs,
+		locks:       newShipmentLocks(d.Get("parallelism_per_shipment").(int)),
 	}
 
 	return &meta, nil
 }
 
 type harborMeta struct {
-	auth                        *Auth
-	existingShipmentEnvironment *ShipmentEnvironment
+	client *HarborClient
+
+	//cancelled when terraform is interrupted
+	stopCtx context.Context
+
+	//environments deleted during this run, for re-attaching user images on create
+	deletedEnvs *deletedEnvironments
+
+	//serializes mutating operations on the same shipment
+	locks *shipmentLocks
 }
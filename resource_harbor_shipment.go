@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"net/http"
-	"strconv"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
 )
@@ -19,6 +21,11 @@ func resourceHarborShipment() *schema.Resource {
 			State: resourceHarborShipmentImport,
 		},
 
+		//bounds deleting any remaining environments (force_destroy)
+		Timeouts: &schema.ResourceTimeout{
+			Delete: schema.DefaultTimeout(defaultEnvTimeout),
+		},
+
 		Schema: map[string]*schema.Schema{
 			"shipment": &schema.Schema{
 				Type:     schema.TypeString,
@@ -29,29 +36,43 @@ func resourceHarborShipment() *schema.Resource {
 				Type:     schema.TypeString,
 				Required: true,
 			},
+			"force_destroy": &schema.Schema{
+				Description: "Scale down and delete any remaining environments when the shipment is destroyed. Otherwise, destroying a shipment that still has environments fails",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"deletion_protection": &schema.Schema{
+				Description: "Prevent the shipment from being destroyed (or replaced). Must be set to false in a prior apply before it can be destroyed",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
 		},
 	}
 }
 
 func resourceHarborShipmentCreate(d *schema.ResourceData, meta interface{}) error {
-	auth := meta.(*harborMeta).auth
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
 
 	shipment := Shipment{
 		Name:  d.Get("shipment").(string),
 		Group: d.Get("group").(string),
 	}
 
+	unlock, err := meta.(*harborMeta).locks.lock(ctx, shipment.Name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	//POST /v1/shipments
 	writeMetric(metricShipmentCreate)
-	uri := shipitURI("/v1/shipments")
-	res, _, err := create(auth.Username, auth.Token, uri, shipment)
-	if err != nil && len(err) > 0 {
-		return err[0]
-	}
-	if res.StatusCode != http.StatusCreated {
-		newErr := errors.New("unable to create shipment: " + err[0].Error())
-		writeMetricError(metricShipmentCreate, newErr)
-		check(newErr)
+	err = client.CreateShipment(ctx, shipment)
+	if err != nil {
+		writeMetricError(metricShipmentCreate, err)
+		return err
 	}
 
 	//create the required shipment envvar for customer/group
@@ -60,17 +81,10 @@ func resourceHarborShipmentCreate(d *schema.ResourceData, meta interface{}) erro
 		Value: shipment.Group,
 	}
 
-	//POST /v1/shipment/:Shipment/envVars
-	uri = shipitURI("/v1/shipment/{shipment}/envVars", param("shipment", shipment.Name))
-	res, _, err = create(auth.Username, auth.Token, uri, customerEnvVar)
-	if err != nil && len(err) > 0 {
-		writeMetricErrorString(metricShipmentCreate, "unable to create shipment envvar: "+err[0].Error())
-		return err[0]
-	}
-	if res.StatusCode != http.StatusCreated {
-		msg := "unable to create shipment envvar: status code = " + strconv.Itoa(res.StatusCode)
-		writeMetricErrorString(metricShipmentCreate, msg)
-		return errors.New(msg)
+	err = client.CreateShipmentEnvVar(ctx, shipment.Name, customerEnvVar)
+	if err != nil {
+		writeMetricError(metricShipmentCreate, err)
+		return err
 	}
 
 	d.SetId(shipment.Name)
@@ -79,28 +93,65 @@ func resourceHarborShipmentCreate(d *schema.ResourceData, meta interface{}) erro
 }
 
 func resourceHarborShipmentDelete(d *schema.ResourceData, meta interface{}) error {
-	auth := meta.(*harborMeta).auth
-	writeMetric(metricShipmentDelete)
-	uri := shipitURI("/v1/shipment/{shipment}", param("shipment", d.Id()))
-	res, _, err := deleteHTTP(auth.Username, auth.Token, uri)
-	if res.StatusCode != http.StatusOK {
-		newErr := errors.New("shipment delete failed: status code = " + strconv.Itoa(res.StatusCode))
-		writeMetricError(metricShipmentDelete, newErr)
-		return newErr
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
+
+	if d.Get("deletion_protection").(bool) {
+		return fmt.Errorf("shipment %v can't be destroyed (or replaced) because deletion_protection is set. "+
+			"Set deletion_protection = false and apply before destroying it", d.Id())
+	}
+
+	unlock, err := meta.(*harborMeta).locks.lock(ctx, d.Id())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	//shipit doesn't check for environments, so make sure they're not orphaned
+	envs, err := client.GetShipmentEnvironments(ctx, d.Id())
+	if err != nil {
+		return err
+	}
+	if len(envs) > 0 {
+		if !d.Get("force_destroy").(bool) {
+			return fmt.Errorf("shipment %v still has environments: %v. "+
+				"Destroy them first, or set force_destroy = true and apply to delete them with the shipment",
+				d.Id(), strings.Join(envs, ", "))
+		}
+
+		deadline := time.Now().Add(d.Timeout(schema.TimeoutDelete))
+		for _, env := range envs {
+			err = forceDestroyShipmentEnvironment(ctx, client, d.Id(), env, time.Until(deadline))
+			if err != nil {
+				writeMetricError(metricShipmentDelete, err)
+				return err
+			}
+		}
 	}
-	if err != nil && len(err) > 0 {
-		writeMetricErrorString(metricShipmentDelete, "shipment delete failed: "+err[0].Error())
-		return err[0]
+
+	writeMetric(metricShipmentDelete)
+	err = client.DeleteShipment(ctx, d.Id())
+	if err != nil {
+		writeMetricError(metricShipmentDelete, err)
+		return err
 	}
 
 	return nil
 }
 
 func resourceHarborShipmentUpdate(d *schema.ResourceData, meta interface{}) error {
-	auth := meta.(*harborMeta).auth
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
 
 	if d.HasChange("group") {
 
+		//the group is copied into every environment, so don't change it while they are being saved
+		unlock, err := meta.(*harborMeta).locks.lock(ctx, d.Id())
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
 		data := Shipment{
 			Group: d.Get("group").(string),
 		}
@@ -111,40 +162,49 @@ func resourceHarborShipmentUpdate(d *schema.ResourceData, meta interface{}) erro
 		}
 
 		writeMetric(metricShipmentUpdate)
-		uri := shipitURI("/v1/shipment/{shipment}/envVar/{envVar}",
-			param("shipment", d.Id()),
-			param("envVar", "CUSTOMER"))
-		res, _, err := update(auth.Username, auth.Token, uri, customerEnvVar)
-		if res.StatusCode != http.StatusOK {
-			newErr := errors.New("shipment envvar update failed: status code = " + strconv.Itoa(res.StatusCode))
-			writeMetricError(metricShipmentUpdate, newErr)
-			return newErr
-		}
-		if err != nil && len(err) > 0 {
-			return err[0]
+		err = client.UpdateShipmentEnvVar(ctx, d.Id(), "CUSTOMER", customerEnvVar)
+		if err != nil {
+			writeMetricError(metricShipmentUpdate, err)
+			return err
 		}
 
 		//now update the shipment
-		uri = shipitURI("/v1/shipment/{shipment}", param("shipment", d.Id()))
-		res, _, err = update(auth.Username, auth.Token, uri, data)
-		if res.StatusCode != http.StatusOK {
-			newErr := errors.New("shipment update failed: status code = " + strconv.Itoa(res.StatusCode))
-			writeMetricError(metricShipmentUpdate, newErr)
-			return newErr
-		}
-		if err != nil && len(err) > 0 {
-			newErr := errors.New("shipment update failed: " + err[0].Error())
-			writeMetricError(metricShipmentUpdate, newErr)
-			return newErr
+		err = client.UpdateShipment(ctx, d.Id(), data)
+		if err != nil {
+			writeMetricError(metricShipmentUpdate, err)
+			return err
 		}
 	}
 	return nil
 }
 
+//forceDestroyShipmentEnvironment drains and deletes an environment (used by force_destroy)
+func forceDestroyShipmentEnvironment(ctx context.Context, client *HarborClient, shipment string, env string, timeout time.Duration) error {
+	logInfo("force_destroy: deleting environment %v", envID(shipment, env))
+
+	shipmentEnv, err := client.GetShipmentEnvironment(ctx, shipment, env)
+	if err != nil {
+		return err
+	}
+	if shipmentEnv == nil {
+		return nil
+	}
+	provider := ec2Provider(shipmentEnv.Providers)
+	if provider == nil {
+		return fmt.Errorf("%v: ec2 provider is missing", envID(shipment, env))
+	}
+
+	return drainAndDeleteShipmentEnvironment(ctx, client, provider.Barge, shipment, env, timeout, time.Duration(defaultPollInterval)*time.Second)
+}
+
 //has the resource been deleted outside of terraform?
 func resourceHarborShipmentExists(d *schema.ResourceData, meta interface{}) (bool, error) {
-	auth := meta.(*harborMeta).auth
-	shipment := GetShipment(auth.Username, auth.Token, d.Id())
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
+	shipment, err := client.GetShipment(ctx, d.Id())
+	if err != nil {
+		return false, err
+	}
 	if shipment == nil {
 		d.SetId("")
 		return false, nil
@@ -155,8 +215,12 @@ func resourceHarborShipmentExists(d *schema.ResourceData, meta interface{}) (boo
 //can assume resoure exists (since tf calls exists)
 //remote data should be updated into the local data
 func resourceHarborShipmentRead(d *schema.ResourceData, meta interface{}) error {
-	auth := meta.(*harborMeta).auth
-	shipment := GetShipment(auth.Username, auth.Token, d.Id())
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
+	shipment, err := client.GetShipment(ctx, d.Id())
+	if err != nil {
+		return err
+	}
 	if shipment == nil {
 		return errors.New("shipment doesn't exist")
 	}
@@ -169,9 +233,14 @@ func resourceHarborShipmentRead(d *schema.ResourceData, meta interface{}) error
 func resourceHarborShipmentImport(d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
 
 	//lookup and set the arguments
-	auth := meta.(*harborMeta).auth
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
 	writeMetric(metricShipmentImport)
-	shipment := GetShipment(auth.Username, auth.Token, d.Id())
+	shipment, err := client.GetShipment(ctx, d.Id())
+	if err != nil {
+		writeMetricError(metricShipmentImport, err)
+		return nil, err
+	}
 	if shipment == nil {
 		newErr := errors.New("shipment doesn't exist")
 		writeMetricError(metricShipmentImport, newErr)
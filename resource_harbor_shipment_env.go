@@ -1,10 +1,10 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -28,6 +28,12 @@ func resourceHarborShipmentEnv() *schema.Resource {
 			State: resourceHarborShipmentEnvironmentImport,
 		},
 
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultEnvTimeout),
+			Update: schema.DefaultTimeout(defaultEnvTimeout),
+			Delete: schema.DefaultTimeout(defaultEnvTimeout),
+		},
+
 		Schema: map[string]*schema.Schema{
 			"shipment": &schema.Schema{
 				Type:     schema.TypeString,
@@ -249,41 +255,122 @@ func resourceHarborShipmentEnv() *schema.Resource {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
+			"fingerprint": &schema.Schema{
+				Description: "Hashes of the environment's settings when it was last read, used to detect changes made outside of terraform",
+				Type:        schema.TypeMap,
+				Computed:    true,
+			},
+			"poll_interval": &schema.Schema{
+				Description: "Seconds between status checks while waiting for the environment",
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     defaultPollInterval,
+			},
+			"wait_for_healthy": &schema.Schema{
+				Description: "After triggering, wait until the containers are running and ready",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"min_ready_replicas": &schema.Schema{
+				Description: "Number of replicas that must be ready when wait_for_healthy is set (0 means all replicas)",
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     0,
+			},
+			"healthy_timeout": &schema.Schema{
+				Description: "Seconds to wait for the containers to become healthy when wait_for_healthy is set",
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     defaultHealthyTimeout,
+			},
+			"retain_on_destroy": &schema.Schema{
+				Description: "Keep the environment (and its build token and env vars) in harbor when the resource is destroyed; terraform just stops managing it",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"scale_to_zero_on_destroy": &schema.Schema{
+				Description: "When retain_on_destroy is set, scale the retained environment to 0 replicas",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"deletion_protection": &schema.Schema{
+				Description: "Prevent the environment from being destroyed (or replaced). Must be set to false in a prior apply before it can be destroyed",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"rollback_on_failure": &schema.Schema{
+				Description: "If the trigger (or waiting for the new configuration) fails after an update was saved, restore and trigger the previous configuration",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
+			"force_overwrite": &schema.Schema{
+				Description: "Overwrite changes made outside of terraform (e.g., harbor-compose or the harbor ui) since the environment was last read",
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+			},
 		},
 	}
 }
 
 func resourceHarborShipmentEnvironmentCreate(d *schema.ResourceData, meta interface{}) error {
-	if Verbose {
-		log.Println("resourceHarborShipmentEnvironmentCreate enter")
-	}
+	logTrace("resourceHarborShipmentEnvironmentCreate enter")
 	harborMeta := meta.(*harborMeta)
-	auth := harborMeta.auth
+	client := harborMeta.client
+	ctx := harborMeta.stopCtx
 
 	shipmentName := d.Get("shipment").(string)
 	environment := d.Get("environment").(string)
 
+	unlock, err := harborMeta.locks.lock(ctx, shipmentName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	//lookup the shipment in order to get the group/envvars (required for bulk creating env)
-	shipment := GetShipment(auth.Username, auth.Token, shipmentName)
+	shipment, err := client.GetShipment(ctx, shipmentName)
+	if err != nil {
+		return err
+	}
 	if shipment == nil {
 		return errors.New("shipment not found")
 	}
 
-	//transform tf resource data into shipit model
-	shipmentEnv, err := transformTerraformToShipmentEnvironment(d, harborMeta.existingShipmentEnvironment, shipment.Group, shipment.EnvVars)
+	//adopt the environment if it exists in shipit but not in state
+	//(e.g., the state was lost after a create saved it)
+	existingShipmentEnv, err := client.GetShipmentEnvironment(ctx, shipmentName, environment)
 	if err != nil {
 		return err
 	}
+	restored := false
+	if existingShipmentEnv != nil {
+		logInfo("%v already exists in shipit, adopting it", envID(shipmentName, environment))
+	} else {
+		//re-attach user images/envvars if this environment was previously deleted (e.g., ForceNew)
+		existingShipmentEnv, err = harborMeta.deletedEnvs.get(shipmentName, environment)
+		if err != nil {
+			return err
+		}
+		if existingShipmentEnv != nil {
+			logInfo("restoring images and env vars from deleted environment %v: %v", envID(shipmentName, environment), describeSnapshot(existingShipmentEnv))
+			restored = true
+		}
+	}
 
-	//add auth
-	shipmentEnv.Username = auth.Username
-	shipmentEnv.Token = auth.Token
+	//transform tf resource data into shipit model
+	shipmentEnv, err := transformTerraformToShipmentEnvironment(d, existingShipmentEnv, shipment.Group, shipment.EnvVars)
+	if err != nil {
+		return err
+	}
 
 	//debug print json
-	if Verbose {
-		b, _ := json.MarshalIndent(shipmentEnv, "\t", "\t")
-		log.Println(string(b))
-	}
+	logTrace("shipment environment payload:\n%v", redactPayload(shipmentEnv))
 
 	//validate before saving
 	err = validateShipmentEnvironment(shipmentEnv)
@@ -293,58 +380,62 @@ func resourceHarborShipmentEnvironmentCreate(d *schema.ResourceData, meta interf
 
 	//save shipment/environment
 	writeMetric(metricEnvCreate)
-	saveSuccess, buildToken := SaveShipmentEnvironment(auth.Username, auth.Token, *shipmentEnv)
-	if !saveSuccess {
-		newErr := fmt.Errorf("SaveShipmentEnvironment failed")
+	buildToken, err := client.SaveShipmentEnvironment(ctx, *shipmentEnv)
+	if err != nil {
+		newErr := describeSaveError(shipmentName, environment, err)
 		writeMetricError(metricEnvCreate, newErr)
 		return newErr
 	}
+	if buildToken == "" && existingShipmentEnv != nil {
+		buildToken = existingShipmentEnv.BuildToken
+	}
 
-	//trigger shipment
-	success, messages := Trigger(shipmentName, environment)
-	if !success {
-		failureMessage := ""
-		for _, m := range messages {
-			failureMessage += m + "\n"
-		}
-		newErr := fmt.Errorf("trigger failed: %v", failureMessage)
-		writeMetricError(metricEnvCreate, newErr)
-		return newErr
+	//the environment now exists in shipit, so record it in state right away in case the
+	//trigger or polling fails. terraform taints a create that fails after the id is set,
+	//so the next apply replaces it (restoring from the snapshot); the adopt path above only
+	//runs when the state was lost entirely.
+	d.Partial(true)
+	d.SetId(envID(shipmentName, environment))
+	for _, key := range configuredAttributes() {
+		d.SetPartial(key)
 	}
 
-	//poll lb endpoint until it's ready
-	var lbStatus *LoadBalancer
-	for {
-		result, err := getLoadBalancerStatus(shipmentName, environment)
-		if err != nil {
-			return err
+	//the snapshot has been restored, no need to keep it around
+	if restored {
+		if err := harborMeta.deletedEnvs.remove(shipmentName, environment); err != nil {
+			logWarn("unable to remove snapshot for %v: %v", envID(shipmentName, environment), err)
 		}
+	}
 
-		//load balancer state should go from "provisioning" to "active"
-		if result != nil {
+	//trigger shipment
+	_, err = client.Trigger(ctx, shipmentName, environment)
+	if err != nil {
+		writeMetricError(metricEnvCreate, err)
+		return err
+	}
 
-			//exist polling loop when active
-			if strings.HasPrefix(result.State, "active") {
-				lbStatus = result
-				break
-			}
+	//poll lb endpoint until it's ready
+	lbStatus, err := waitForLoadBalancer(ctx, client, d, shipmentName, environment, d.Timeout(schema.TimeoutCreate))
+	if err != nil {
+		return err
+	}
 
-			if result.State != "provisioning" {
-				return errors.New("LB state = " + result.State)
-			}
+	//make sure the containers aren't crash-looping
+	if d.Get("wait_for_healthy").(bool) {
+		err = waitForHealthy(ctx, client, d, shipmentName, environment)
+		if err != nil {
+			writeMetricError(metricEnvCreate, err)
+			return err
 		}
-
-		//wait a few seconds
-		time.Sleep(10 * time.Second)
 	}
 
-	//output id
-	d.SetId(fmt.Sprintf("%s::%s", shipmentEnv.ParentShipment.Name, shipmentEnv.Name))
+	//everything succeeded, save all attributes
+	d.Partial(false)
 
 	//output attributes
 	setComputedAttributes(d, shipmentName, environment, lbStatus, buildToken)
 
-	return nil
+	return refreshFingerprint(ctx, client, d, shipmentName, environment)
 }
 
 func validateShipmentEnvironment(shipmentEnv *ShipmentEnvironment) error {
@@ -380,6 +471,79 @@ func validateShipmentEnvironment(shipmentEnv *ShipmentEnvironment) error {
 	return nil
 }
 
+//describeSaveError maps harbor api errors from a bulk save into a message explaining what went wrong
+func describeSaveError(shipment string, env string, err error) error {
+	switch e := err.(type) {
+	case *ValidationError:
+		return fmt.Errorf("shipit rejected the configuration for %v::%v:\n%v", shipment, env, e.FieldMessages())
+	case *ConflictError:
+		return fmt.Errorf("%v::%v already exists or was modified concurrently in shipit: %v", shipment, env, e.Body)
+	case *UnauthorizedError:
+		return fmt.Errorf("not authorized to save %v::%v: %v", shipment, env, e)
+	case *NotFoundError:
+		return fmt.Errorf("shipment '%v' not found in shipit", shipment)
+	case *ServerError:
+		return fmt.Errorf("shipit failed to save %v::%v (status code = %v): %v", shipment, env, e.StatusCode, e.Body)
+	}
+	return fmt.Errorf("SaveShipmentEnvironment failed: %v", err)
+}
+
+//rollbackShipmentEnvironment re-saves and triggers the previous configuration of an environment
+//after an update failed to deploy. the returned error describes both the failure and the rollback.
+func rollbackShipmentEnvironment(ctx context.Context, client *HarborClient, previous *ShipmentEnvironment, cause error) error {
+	id := envID(previous.ParentShipment.Name, previous.Name)
+	logWarn("update of %v failed, rolling back to the previous configuration: %v", id, cause)
+
+	//still roll back if the failure was terraform being interrupted
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+
+	_, err := client.SaveShipmentEnvironment(ctx, *previous)
+	if err != nil {
+		return fmt.Errorf("%v\nrollback of %v failed, unable to save the previous configuration: %v", cause, id, err)
+	}
+
+	return triggerRollback(ctx, client, previous, cause)
+}
+
+//rollbackShipmentEnvironmentInPlace restores the previous replicas, monitored and iam_role
+//after an in-place update fails, without re-saving the rest of the environment
+func rollbackShipmentEnvironmentInPlace(ctx context.Context, client *HarborClient, d *schema.ResourceData, previous *ShipmentEnvironment, cause error) error {
+	id := envID(previous.ParentShipment.Name, previous.Name)
+	logWarn("update of %v failed, rolling back to the previous replicas, monitored and iam_role: %v", id, cause)
+
+	//still roll back if the failure was terraform being interrupted
+	if ctx.Err() != nil {
+		ctx = context.Background()
+	}
+
+	//fall back to the state if the environment didn't have an ec2 provider
+	replicas, _ := d.GetChange("replicas")
+	if provider := ec2Provider(previous.Providers); provider != nil {
+		replicas = provider.Replicas
+	}
+
+	err := updateShipmentEnvironmentInPlace(ctx, client, d, previous.ParentShipment.Name, previous.Name, replicas.(int), previous.EnableMonitoring, previous.IamRole)
+	if err != nil {
+		return fmt.Errorf("%v\nrollback of %v failed, unable to restore the previous configuration: %v", cause, id, err)
+	}
+
+	return triggerRollback(ctx, client, previous, cause)
+}
+
+//triggerRollback deploys the previous configuration once it has been restored in shipit
+func triggerRollback(ctx context.Context, client *HarborClient, previous *ShipmentEnvironment, cause error) error {
+	id := envID(previous.ParentShipment.Name, previous.Name)
+
+	_, err := client.Trigger(ctx, previous.ParentShipment.Name, previous.Name)
+	if err != nil {
+		return fmt.Errorf("%v\nrollback of %v failed, the previous configuration was restored but couldn't be triggered: %v", cause, id, err)
+	}
+
+	return fmt.Errorf("%v\n%v was rolled back to its previous configuration", cause, id)
+}
+
 func idParts(id string) (string, string) {
 	parts := strings.Split(id, "::")
 	return parts[0], parts[1]
@@ -387,41 +551,116 @@ func idParts(id string) (string, string) {
 
 func resourceHarborShipmentEnvironmentDelete(d *schema.ResourceData, meta interface{}) error {
 	harborMeta := meta.(*harborMeta)
-	auth := harborMeta.auth
+	client := harborMeta.client
+	ctx := harborMeta.stopCtx
 	shipment, env := idParts(d.Id())
 
-	shipmentEnv := GetShipmentEnvironment(auth.Username, auth.Token, shipment, env)
+	if d.Get("deletion_protection").(bool) {
+		return fmt.Errorf("%v can't be destroyed (or replaced) because deletion_protection is set. "+
+			"Set deletion_protection = false and apply before destroying it", envID(shipment, env))
+	}
+
+	unlock, err := harborMeta.locks.lock(ctx, shipment)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	shipmentEnv, err := client.GetShipmentEnvironment(ctx, shipment, env)
+	if err != nil {
+		return err
+	}
 	if shipmentEnv == nil {
 		return errors.New("shipment/environment doesn't exist")
 	}
 
+	//leave the shipit record alone
+	if d.Get("retain_on_destroy").(bool) {
+		return retainShipmentEnvironment(ctx, client, d, shipment, env)
+	}
+
+	//snapshot the ShipmentEnvironment (keyed by shipment::env) before deleting it
+	//so that a subsequent create of the same environment can re-attach user images,
+	//even if this apply fails before the create
+	err = harborMeta.deletedEnvs.put(shipment, env, shipmentEnv)
+	if err != nil {
+		logWarn("unable to save snapshot for %v: %v", envID(shipment, env), err)
+	}
+
+	barge := d.Get("barge").(string)
+	if provider := ec2Provider(shipmentEnv.Providers); provider != nil {
+		barge = provider.Barge
+	}
+
 	writeMetric(metricEnvDelete)
+	err = drainAndDeleteShipmentEnvironment(ctx, client, barge, shipment, env, d.Timeout(schema.TimeoutDelete), pollInterval(d))
+	if err != nil {
+		writeMetricError(metricEnvDelete, err)
+		return err
+	}
+
+	return nil
+}
+
+//drainAndDeleteShipmentEnvironment scales an environment to zero and triggers it, waits (up to timeout)
+//for the containers to stop and the load balancer to be torn down, and then deletes it from shipit.
+//waiting means that a subsequent create (e.g., ForceNew) doesn't race with the old environment.
+func drainAndDeleteShipmentEnvironment(ctx context.Context, client *HarborClient, barge string, shipment string, env string, timeout time.Duration, interval time.Duration) error {
 
 	//set replicas to 0 and trigger
-	provider := ProviderPayload{
+	err := client.UpdateProvider(ctx, shipment, env, ProviderPayload{
 		Name:     providerEc2,
 		Replicas: 0,
+	})
+	if err != nil {
+		return err
 	}
-	UpdateProvider(auth.Username, auth.Token, shipment, env, provider)
 
-	//trigger shipment
-	Trigger(shipment, env)
+	_, err = client.Trigger(ctx, shipment, env)
+	if err != nil {
+		return err
+	}
+
+	err = waitForDrained(ctx, client, barge, shipment, env, timeout, interval)
+	if err != nil {
+		return err
+	}
 
 	//now delete from shipit
-	DeleteShipmentEnvironment(auth.Username, auth.Token, shipment, env)
+	return client.DeleteShipmentEnvironment(ctx, shipment, env)
+}
 
-	//store the existing ShipmentEnvironment in meta state
-	//so that create can re-attach user images
-	harborMeta.existingShipmentEnvironment = shipmentEnv
+//retainShipmentEnvironment removes an environment from terraform without deleting it from shipit,
+//optionally scaling it to zero
+func retainShipmentEnvironment(ctx context.Context, client *HarborClient, d *schema.ResourceData, shipment string, env string) error {
+	if !d.Get("scale_to_zero_on_destroy").(bool) {
+		logInfo("retaining %v in harbor (retain_on_destroy is set)", envID(shipment, env))
+		return nil
+	}
 
-	return nil
+	logInfo("retaining %v in harbor and scaling it to zero (retain_on_destroy and scale_to_zero_on_destroy are set)", envID(shipment, env))
+	provider := ProviderPayload{
+		Name:     providerEc2,
+		Replicas: 0,
+	}
+	err := client.UpdateProvider(ctx, shipment, env, provider)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Trigger(ctx, shipment, env)
+	return err
 }
 
 //has the resource been deleted outside of terraform?
 func resourceHarborShipmentEnvironmentExists(d *schema.ResourceData, meta interface{}) (bool, error) {
-	auth := meta.(*harborMeta).auth
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
 	shipment, env := idParts(d.Id())
-	shipmentEnv := GetShipmentEnvironment(auth.Username, auth.Token, shipment, env)
+	shipmentEnv, err := client.GetShipmentEnvironment(ctx, shipment, env)
+	if err != nil {
+		return false, err
+	}
 	if shipmentEnv == nil {
 		d.SetId("")
 		return false, nil
@@ -432,15 +671,19 @@ func resourceHarborShipmentEnvironmentExists(d *schema.ResourceData, meta interf
 //can assume resoure exists (since tf calls exists)
 //remote data should be updated into the local data
 func resourceHarborShipmentEnvironmentRead(d *schema.ResourceData, meta interface{}) error {
-	auth := meta.(*harborMeta).auth
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
 	shipment, env := idParts(d.Id())
-	shipmentEnv := GetShipmentEnvironment(auth.Username, auth.Token, shipment, env)
+	shipmentEnv, err := client.GetShipmentEnvironment(ctx, shipment, env)
+	if err != nil {
+		return err
+	}
 	if shipmentEnv == nil {
 		return errors.New("shipment/environment doesn't exist")
 	}
 
 	//transform shipit model back to terraform
-	err := transformShipmentEnvironmentToTerraform(shipmentEnv, d)
+	err = transformShipmentEnvironmentToTerraform(shipmentEnv, d)
 	if err != nil {
 		return err
 	}
@@ -453,9 +696,14 @@ func resourceHarborShipmentEnvironmentImport(d *schema.ResourceData, meta interf
 	writeMetric(metricEnvImport)
 
 	//lookup and set the arguments
-	auth := meta.(*harborMeta).auth
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
 	shipment, env := idParts(d.Id())
-	shipmentEnv := GetShipmentEnvironment(auth.Username, auth.Token, shipment, env)
+	shipmentEnv, err := client.GetShipmentEnvironment(ctx, shipment, env)
+	if err != nil {
+		writeMetricError(metricEnvImport, err)
+		return nil, err
+	}
 	if shipmentEnv == nil {
 		newErr := errors.New("shipment/environment doesn't exist")
 		writeMetricError(metricEnvImport, newErr)
@@ -463,14 +711,14 @@ func resourceHarborShipmentEnvironmentImport(d *schema.ResourceData, meta interf
 	}
 
 	//transform shipit model back to terraform
-	err := transformShipmentEnvironmentToTerraform(shipmentEnv, d)
+	err = transformShipmentEnvironmentToTerraform(shipmentEnv, d)
 	if err != nil {
 		writeMetricError(metricEnvImport, err)
 		return nil, err
 	}
 
 	//call the load balancer api
-	lbStatus, err := getLoadBalancerStatus(shipment, env)
+	lbStatus, err := client.getLoadBalancerStatus(ctx, shipment, env)
 	if err != nil {
 		return nil, err
 	}
@@ -491,64 +739,201 @@ func setComputedAttributes(d *schema.ResourceData, shipment string, environment
 	d.Set("build_token", buildToken)
 }
 
+//attributes that only affect how the provider behaves (they aren't stored in harbor),
+//so changing them doesn't require a save or trigger
+var providerSettings = map[string]bool{
+	"poll_interval":            true,
+	"wait_for_healthy":         true,
+	"min_ready_replicas":       true,
+	"healthy_timeout":          true,
+	"retain_on_destroy":        true,
+	"scale_to_zero_on_destroy": true,
+	"deletion_protection":      true,
+	"rollback_on_failure":      true,
+	"force_overwrite":          true,
+}
+
+//attributes that have targeted shipit endpoints (see updateShipmentEnvironmentInPlace)
+var inPlaceAttributes = map[string]bool{
+	"replicas":  true,
+	"monitored": true,
+	"iam_role":  true,
+}
+
+//inPlaceChanges returns true if every change can be applied without a bulk save
+func inPlaceChanges(changes []string) bool {
+	for _, key := range changes {
+		if !inPlaceAttributes[key] {
+			return false
+		}
+	}
+	return true
+}
+
+//configuredAttributes returns the attributes that are set from configuration (i.e., not computed only)
+func configuredAttributes() []string {
+	var keys []string
+	for key, s := range resourceHarborShipmentEnv().Schema {
+		if s.Computed && !s.Optional && !s.Required {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//harborChanges returns the changed attributes that are stored in harbor
+func harborChanges(d *schema.ResourceData) []string {
+	var changes []string
+	for _, key := range configuredAttributes() {
+		if !providerSettings[key] && d.HasChange(key) {
+			changes = append(changes, key)
+		}
+	}
+	return changes
+}
+
 //make updates to remote resource (use shipit bulk and trigger)
 func resourceHarborShipmentEnvironmentUpdate(d *schema.ResourceData, meta interface{}) error {
-	harborMeta := meta.(*harborMeta)
-	auth := harborMeta.auth
+	client := meta.(*harborMeta).client
+	ctx := meta.(*harborMeta).stopCtx
 	shipmentName, env := idParts(d.Id())
 
-	//lookup existing shipment/env
-	shipmentEnv := GetShipmentEnvironment(auth.Username, auth.Token, shipmentName, env)
-	if shipmentEnv == nil {
-		return errors.New("shipment/environment doesn't exist")
+	//nothing to do in harbor if only provider settings (e.g., deletion_protection) changed
+	changes := harborChanges(d)
+	if len(changes) == 0 {
+		return nil
 	}
+	logDebug("updating %v: %v changed", d.Id(), strings.Join(changes, ", "))
 
-	//transform tf resource data into shipit model
-	shipmentEnv, err := transformTerraformToShipmentEnvironment(d, shipmentEnv, shipmentEnv.ParentShipment.Group, shipmentEnv.ParentShipment.EnvVars)
+	unlock, err := meta.(*harborMeta).locks.lock(ctx, shipmentName)
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	shipmentEnv.Username = auth.Username
-	shipmentEnv.Token = auth.Token
+	//lookup existing shipment/env
+	shipmentEnv, err := client.GetShipmentEnvironment(ctx, shipmentName, env)
+	if err != nil {
+		return err
+	}
+	if shipmentEnv == nil {
+		return errors.New("shipment/environment doesn't exist")
+	}
 
-	//debug print json
-	if Verbose {
-		b, _ := json.MarshalIndent(shipmentEnv, "\t", "\t")
-		log.Println(string(b))
+	//make sure nothing else has changed the environment since terraform last read it
+	if !d.Get("force_overwrite").(bool) {
+		err = checkFingerprint(d, shipmentEnv)
+		if err != nil {
+			return err
+		}
 	}
 
-	//validate before saving
-	err = validateShipmentEnvironment(shipmentEnv)
-	if err != nil {
+	//once shipit has the new configuration, roll back to the current one if it can't be deployed
+	inPlace := inPlaceChanges(changes)
+	failed := func(err error) error {
+		writeMetricError(metricEnvUpdate, err)
+		if d.Get("rollback_on_failure").(bool) {
+			//keep the previous configuration in state
+			d.Partial(true)
+			if inPlace {
+				return rollbackShipmentEnvironmentInPlace(ctx, client, d, shipmentEnv, err)
+			}
+			return rollbackShipmentEnvironment(ctx, client, shipmentEnv, err)
+		}
 		return err
 	}
 
-	//save shipment/environment
-	writeMetric(metricEnvUpdate)
-	SaveShipmentEnvironment(auth.Username, auth.Token, *shipmentEnv)
+	if !inPlace {
 
-	//trigger shipment
-	success, messages := Trigger(shipmentName, env)
-	if !success {
-		failureMessage := ""
-		for _, m := range messages {
-			failureMessage += m + "\n"
+		//transform tf resource data into shipit model
+		payload, err := transformTerraformToShipmentEnvironment(d, shipmentEnv, shipmentEnv.ParentShipment.Group, shipmentEnv.ParentShipment.EnvVars)
+		if err != nil {
+			return err
+		}
+
+		//debug print json
+		logTrace("shipment environment payload:\n%v", redactPayload(payload))
+
+		//validate before saving
+		err = validateShipmentEnvironment(payload)
+		if err != nil {
+			return err
+		}
+
+		//save shipment/environment
+		writeMetric(metricEnvUpdate)
+		_, err = client.SaveShipmentEnvironment(ctx, *payload)
+		if err != nil {
+			newErr := describeSaveError(shipmentName, env, err)
+			writeMetricError(metricEnvUpdate, newErr)
+			return newErr
+		}
+	} else {
+
+		//replicas, monitored and iam_role have targeted endpoints, so don't re-post every
+		//container and port (and risk clobbering concurrent harbor-compose edits)
+		writeMetric(metricEnvUpdate)
+		err = updateShipmentEnvironmentInPlace(ctx, client, d, shipmentName, env, d.Get("replicas").(int), d.Get("monitored").(bool), d.Get("iam_role").(string))
+		if err != nil {
+			return failed(err)
 		}
-		newErr := fmt.Errorf("trigger failed: %v", failureMessage)
-		writeMetricError(metricEnvUpdate, newErr)
-		return newErr
 	}
 
-	//call the load balancer api
-	lbStatus, err := getLoadBalancerStatus(shipmentName, env)
+	//trigger shipment
+	_, err = client.Trigger(ctx, shipmentName, env)
 	if err != nil {
-		return err
+		return failed(err)
+	}
+
+	//make sure the load balancer is (still) active
+	lbStatus, err := waitForLoadBalancer(ctx, client, d, shipmentName, env, d.Timeout(schema.TimeoutUpdate))
+	if err != nil {
+		return failed(err)
+	}
+
+	//make sure the new configuration isn't crash-looping
+	if d.Get("wait_for_healthy").(bool) {
+		err = waitForHealthy(ctx, client, d, shipmentName, env)
+		if err != nil {
+			return failed(err)
+		}
 	}
 
 	//set computed attributes
 	setComputedAttributes(d, shipmentName, env, lbStatus, shipmentEnv.BuildToken)
 
+	return refreshFingerprint(ctx, client, d, shipmentName, env)
+}
+
+//updateShipmentEnvironmentInPlace sets replicas, monitored and iam_role (whichever changed)
+//without a bulk save
+func updateShipmentEnvironmentInPlace(ctx context.Context, client *HarborClient, d *schema.ResourceData, shipment string, env string, replicas int, monitored bool, iamRole string) error {
+	if d.HasChange("replicas") {
+		provider := ProviderPayload{
+			Name:     providerEc2,
+			Replicas: replicas,
+		}
+		err := client.UpdateProvider(ctx, shipment, env, provider)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.HasChange("monitored") || d.HasChange("iam_role") {
+		request := UpdateShipmentEnvironmentRequest{
+			EnableMonitoring: monitored,
+		}
+		if d.HasChange("iam_role") {
+			request.IamRole = &iamRole
+		}
+		err := client.UpdateShipmentEnvironmentSettings(ctx, shipment, env, request)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -556,12 +941,16 @@ func resourceHarborShipmentEnvironmentUpdate(d *schema.ResourceData, meta interf
 func transformShipmentEnvironmentToTerraform(shipmentEnv *ShipmentEnvironment, d *schema.ResourceData) error {
 
 	//set attributes
+	d.Set("fingerprint", fingerprintShipmentEnvironment(shipmentEnv))
 	d.Set("shipment", shipmentEnv.ParentShipment.Name)
 	d.Set("environment", shipmentEnv.Name)
 	d.Set("monitored", shipmentEnv.EnableMonitoring)
 	d.Set("iam_role", shipmentEnv.IamRole)
 
 	provider := ec2Provider(shipmentEnv.Providers)
+	if provider == nil {
+		return errors.New("ec2 provider is missing")
+	}
 	d.Set("barge", provider.Barge)
 	d.Set("replicas", provider.Replicas)
 
@@ -578,7 +967,7 @@ func transformShipmentEnvironmentToTerraform(shipmentEnv *ShipmentEnvironment, d
 	//log shipping
 	envvar := findEnvVar(envVarNameShipLogs, shipmentEnv.EnvVars)
 	if envvar != (EnvVarPayload{}) {
-		log.Println("translating log shipping env vars")
+		logTrace("translating log shipping env vars")
 
 		logShipping := make([]map[string]interface{}, 1)
 		logShippingConfig := make(map[string]interface{})
@@ -720,7 +1109,7 @@ func transformTerraformToShipmentEnvironment(d *schema.ResourceData, existingShi
 	//translate log_shipping configuration into harbor env vars
 	logShippingResource := d.Get("log_shipping") //[]map[string]interface{}
 	if logShipping, ok := logShippingResource.([]interface{}); ok && len(logShipping) > 0 {
-		log.Println("processing log_shipping")
+		logTrace("processing log_shipping")
 		ls := logShipping[0].(map[string]interface{})
 
 		//all providers require SHIP_LOGS and LOGS_ENDPOINT
@@ -758,9 +1147,7 @@ func transformTerraformToShipmentEnvironment(d *schema.ResourceData, existingShi
 				//does this container already exist? (user could be adding a new container)
 				existingContainer := findContainer(result.Containers[i].Name, existingShipmentEnvironment.Containers)
 				if existingContainer.Name != "" {
-					if Verbose {
-						log.Printf("using existing image/envvars for container: %v\n", result.Containers[i].Name)
-					}
+					logDebug("using existing image/envvars for container: %v", result.Containers[i].Name)
 					result.Containers[i].Image = existingContainer.Image
 					useDefaultBackend = false
 				}
@@ -770,9 +1157,7 @@ func transformTerraformToShipmentEnvironment(d *schema.ResourceData, existingShi
 			}
 
 			if useDefaultBackend {
-				if Verbose {
-					log.Printf("using default backend for container: %v\n", result.Containers[i].Name)
-				}
+				logDebug("using default backend for container: %v", result.Containers[i].Name)
 
 				result.Containers[i].Image = fmt.Sprintf("%v:%v", defaultBackendImageName, defaultBackendImageVersion)
 
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+//a misspelled provider setting would make changes to it save and trigger the environment
+func TestProviderSettingsAreInSchema(t *testing.T) {
+	resource := resourceHarborShipmentEnv()
+	for key := range providerSettings {
+		s, ok := resource.Schema[key]
+		if !ok {
+			t.Errorf("%v is not an attribute of harbor_shipment_env", key)
+			continue
+		}
+		if s.ForceNew || s.Computed {
+			t.Errorf("%v is not a provider setting", key)
+		}
+	}
+}
+
+func TestInPlaceChanges(t *testing.T) {
+	cases := []struct {
+		changes []string
+		inPlace bool
+	}{
+		{[]string{"replicas"}, true},
+		{[]string{"iam_role", "monitored", "replicas"}, true},
+		{[]string{"container", "replicas"}, false},
+		{[]string{"annotations"}, false},
+		{[]string{"log_shipping", "monitored"}, false},
+	}
+	for _, c := range cases {
+		if inPlace := inPlaceChanges(c.changes); inPlace != c.inPlace {
+			t.Errorf("inPlaceChanges(%v) = %v, expected %v", c.changes, inPlace, c.inPlace)
+		}
+	}
+}
+
+//a create that fails after saving to shipit records every configured attribute in state
+func TestConfiguredAttributes(t *testing.T) {
+	keys := map[string]bool{}
+	for _, key := range configuredAttributes() {
+		keys[key] = true
+	}
+	for _, key := range []string{"shipment", "environment", "barge", "container", "annotations", "poll_interval", "rollback_on_failure"} {
+		if !keys[key] {
+			t.Errorf("expected %v to be a configured attribute", key)
+		}
+	}
+	for _, key := range []string{"dns_name", "lb_arn", "build_token", "fingerprint"} {
+		if keys[key] {
+			t.Errorf("%v is computed only", key)
+		}
+	}
+}
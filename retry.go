@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const defaultRetryMinWait = 1 * time.Second
+
+// RetryPolicy controls how transient harbor failures (network errors, 5xx, 429) are retried
+type RetryPolicy struct {
+	MaxRetries int
+	MinWait    time.Duration
+	MaxWait    time.Duration
+}
+
+//shouldRetry determines whether a failed request can be safely retried.
+//Idempotent requests are retried on network errors and 5xx/429 responses.
+//POSTs are only retried when the request never reached the server or the
+//server explicitly asks for a retry (429, or 503 with Retry-After).
+func (p RetryPolicy) shouldRetry(method string, res *http.Response, err error) bool {
+	idempotent := method != http.MethodPost
+
+	if err != nil {
+		return idempotent || isDialError(err)
+	}
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		return true
+	case res.StatusCode == http.StatusServiceUnavailable && res.Header.Get("Retry-After") != "":
+		return true
+	case res.StatusCode >= 500 && res.StatusCode != http.StatusNotImplemented:
+		return idempotent
+	}
+
+	return false
+}
+
+//backoff returns how long to wait before retrying, honoring Retry-After and
+//otherwise using exponential backoff with jitter (capped at MaxWait)
+func (p RetryPolicy) backoff(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			if seconds == 0 {
+				return 0
+			}
+			return p.capWait(time.Duration(seconds) * time.Second)
+		}
+	}
+
+	wait := p.capWait(p.MinWait << uint(attempt))
+
+	//spread retries from parallel resources so they don't hit harbor at the same time
+	half := int64(wait / 2)
+	if half <= 0 {
+		return wait
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+func (p RetryPolicy) capWait(wait time.Duration) time.Duration {
+	if p.MaxWait > 0 && (wait > p.MaxWait || wait <= 0) {
+		return p.MaxWait
+	}
+	return wait
+}
+
+//isDialError returns true if the connection was never established (i.e., the request wasn't sent)
+func isDialError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if opErr, ok := err.(*net.OpError); ok {
+		return opErr.Op == "dial"
+	}
+	return false
+}
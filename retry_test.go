@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	dialErr := &url.Error{Op: "Post", URL: "http://shipit", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	readErr := &url.Error{Op: "Post", URL: "http://shipit", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+
+	response := func(status int, retryAfter string) *http.Response {
+		res := &http.Response{StatusCode: status, Header: http.Header{}}
+		if retryAfter != "" {
+			res.Header.Set("Retry-After", retryAfter)
+		}
+		return res
+	}
+
+	cases := []struct {
+		status     int
+		retryAfter string
+		err        error
+		retry      map[string]bool
+	}{
+		{err: dialErr, retry: map[string]bool{"GET": true, "PUT": true, "DELETE": true, "POST": true}},
+		{err: readErr, retry: map[string]bool{"GET": true, "PUT": true, "DELETE": true, "POST": false}},
+		{status: 500, retry: map[string]bool{"GET": true, "PUT": true, "DELETE": true, "POST": false}},
+		{status: 502, retry: map[string]bool{"GET": true, "PUT": true, "DELETE": true, "POST": false}},
+		{status: 503, retry: map[string]bool{"GET": true, "PUT": true, "DELETE": true, "POST": false}},
+		{status: 503, retryAfter: "5", retry: map[string]bool{"GET": true, "PUT": true, "DELETE": true, "POST": true}},
+		{status: 504, retry: map[string]bool{"GET": true, "PUT": true, "DELETE": true, "POST": false}},
+		{status: 501, retry: map[string]bool{"GET": false, "PUT": false, "DELETE": false, "POST": false}},
+		{status: 429, retry: map[string]bool{"GET": true, "PUT": true, "DELETE": true, "POST": true}},
+		{status: 400, retry: map[string]bool{"GET": false, "PUT": false, "DELETE": false, "POST": false}},
+		{status: 404, retry: map[string]bool{"GET": false, "PUT": false, "DELETE": false, "POST": false}},
+		{status: 409, retry: map[string]bool{"GET": false, "PUT": false, "DELETE": false, "POST": false}},
+	}
+
+	policy := RetryPolicy{MaxRetries: 3, MinWait: time.Second, MaxWait: 30 * time.Second}
+	for _, c := range cases {
+		var res *http.Response
+		if c.err == nil {
+			res = response(c.status, c.retryAfter)
+		}
+		for method, expected := range c.retry {
+			if actual := policy.shouldRetry(method, res, c.err); actual != expected {
+				t.Errorf("%v (status %v, Retry-After %q, error %v): expected retry = %v", method, c.status, c.retryAfter, c.err, expected)
+			}
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 10, MinWait: time.Second, MaxWait: 8 * time.Second}
+
+	retryAfter := func(value string) *http.Response {
+		return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": []string{value}}}
+	}
+
+	cases := []struct {
+		attempt  int
+		res      *http.Response
+		min, max time.Duration
+	}{
+		//exponential with jitter
+		{attempt: 0, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 1, min: time.Second, max: 2 * time.Second},
+		{attempt: 2, min: 2 * time.Second, max: 4 * time.Second},
+
+		//capped at MaxWait, including when the shift overflows
+		{attempt: 5, min: 4 * time.Second, max: 8 * time.Second},
+		{attempt: 70, min: 4 * time.Second, max: 8 * time.Second},
+
+		//Retry-After is honored, but still capped
+		{attempt: 0, res: retryAfter("3"), min: 3 * time.Second, max: 3 * time.Second},
+		{attempt: 5, res: retryAfter("0"), min: 0, max: 0},
+		{attempt: 0, res: retryAfter("120"), min: 8 * time.Second, max: 8 * time.Second},
+
+		//unparseable Retry-After falls back to backoff
+		{attempt: 1, res: retryAfter("Wed, 21 Oct 2015 07:28:00 GMT"), min: time.Second, max: 2 * time.Second},
+	}
+
+	for _, c := range cases {
+		//jitter is random, so sample a few times
+		for i := 0; i < 20; i++ {
+			wait := policy.backoff(c.attempt, c.res)
+			if wait < c.min || wait > c.max {
+				t.Errorf("attempt %v: expected a wait between %v and %v, got %v", c.attempt, c.min, c.max, wait)
+				break
+			}
+		}
+	}
+}
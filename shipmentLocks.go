@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+//shipmentLocks serializes mutating operations (save, trigger, lb polling) on the same shipment,
+//since shipit and trigger don't handle concurrent changes to a shipment well. different
+//shipments proceed in parallel. parallelism is the number of concurrent operations allowed per shipment.
+type shipmentLocks struct {
+	mu          sync.Mutex
+	parallelism int
+	shipments   map[string]chan struct{}
+}
+
+func newShipmentLocks(parallelism int) *shipmentLocks {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	return &shipmentLocks{
+		parallelism: parallelism,
+		shipments:   make(map[string]chan struct{}),
+	}
+}
+
+//lock blocks until an operation on shipment may proceed (or ctx is cancelled).
+//the returned func must be called to release the lock.
+func (l *shipmentLocks) lock(ctx context.Context, shipment string) (func(), error) {
+	l.mu.Lock()
+	sem, ok := l.shipments[shipment]
+	if !ok {
+		sem = make(chan struct{}, l.parallelism)
+		l.shipments[shipment] = sem
+	}
+	l.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+	default:
+		logDebug("waiting for other operations on shipment %v to finish", shipment)
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for lock on shipment %v: %v", shipment, ctx.Err())
+		}
+	}
+
+	return func() { <-sem }, nil
+}
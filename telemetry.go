@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"encoding/json"
-	"log"
 	"net/http"
 	"os"
 	"os/user"
@@ -59,8 +58,12 @@ func writeMetricErrorString(action string, err string) {
 	// HARBOR_TELEMETRY=0 disables telemetry
 	if setting := os.Getenv("HARBOR_TELEMETRY"); setting != "0" {
 
+		//telemetry is best effort (e.g., the current user can't be looked up in some containers)
 		user, e := user.Current()
-		check(e)
+		if e != nil {
+			logDebug("skipping telemetry: %v", e)
+			return
+		}
 
 		m := metric{
 			Source:  "terraform-provider-harbor",
@@ -72,9 +75,7 @@ func writeMetricErrorString(action string, err string) {
 			Version: getVersion(),
 		}
 
-		if Verbose {
-			log.Println("posting telemetry data to: " + telemetryURI)
-		}
+		logTrace("posting telemetry data to: %v", telemetryURI)
 
 		//talk to the server in the background to keep things moving
 		go postTelemetryData(m)
@@ -89,7 +90,7 @@ func postTelemetryData(m metric) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("error posting telemetry data: %s\n", err)
+		logDebug("error posting telemetry data: %v", err)
 		return
 	}
 	resp.Body.Close()
@@ -1,10 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
+	"time"
 
 	"github.com/jtacoma/uritemplates"
 )
@@ -31,15 +32,13 @@ func logShippingEnvVars() map[string]string {
 	}
 }
 
-func trace(msg string) {
-	if Verbose {
-		fmt.Println(msg)
-	}
-}
-
-func check(e error) {
-	if e != nil {
-		log.Fatal("ERROR: ", e)
+//sleep waits for d, returning early if ctx is cancelled (e.g., Ctrl-C during an apply)
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
 	}
 }
 
@@ -50,29 +49,32 @@ func ec2Provider(providers []ProviderPayload) *ProviderPayload {
 			return &provider
 		}
 	}
-	log.Fatal("ec2 provider is missing")
 	return nil
 }
 
-func appendToFile(file string, lines []string) {
+func appendToFile(file string, lines []string) error {
 	if _, err := os.Stat(file); err == nil {
 		//update
 		file, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0600)
-		check(err)
+		if err != nil {
+			return err
+		}
 		defer file.Close()
 		for _, line := range lines {
 			_, err = file.WriteString("\n" + line)
-			check(err)
+			if err != nil {
+				return err
+			}
 		}
-	} else {
-		//create
-		data := ""
-		for _, line := range lines {
-			data += line + "\n"
-		}
-		err := ioutil.WriteFile(file, []byte(data), 0644)
-		check(err)
+		return nil
+	}
+
+	//create
+	data := ""
+	for _, line := range lines {
+		data += line + "\n"
 	}
+	return ioutil.WriteFile(file, []byte(data), 0644)
 }
 
 type tuple struct {
@@ -87,16 +89,17 @@ func param(item1 string, item2 string) tuple {
 	}
 }
 
-func buildURI(baseURI string, template string, params ...tuple) string {
+//buildURI expands a uri template (the base uri is user input, e.g., shipit_url, so it may be invalid)
+func buildURI(baseURI string, template string, params ...tuple) (string, error) {
 	uriTemplate, err := uritemplates.Parse(baseURI + template)
-	check(err)
+	if err != nil {
+		return "", fmt.Errorf("invalid uri %v: %v", baseURI+template, err)
+	}
 	values := make(map[string]interface{})
 	for _, v := range params {
 		values[v.Item1] = v.Item2
 	}
-	uri, err := uriTemplate.Expand(values)
-	check(err)
-	return uri
+	return uriTemplate.Expand(values)
 }
 
 func findContainer(container string, containers []ContainerPayload) ContainerPayload {
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+const (
+	defaultEnvTimeout   = 30 * time.Minute
+	defaultPollInterval = 10
+
+	//seconds
+	defaultHealthyTimeout = 600
+)
+
+//pollInterval returns the resource's configured time between status checks
+func pollInterval(d *schema.ResourceData) time.Duration {
+	seconds := d.Get("poll_interval").(int)
+
+	//state written by an older version of the provider
+	if seconds <= 0 {
+		seconds = defaultPollInterval
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+//waitForLoadBalancer polls the load balancer until it's active. if timeout expires first,
+//the error includes the last observed lb state and the status of each container.
+func waitForLoadBalancer(ctx context.Context, client *HarborClient, d *schema.ResourceData, shipment string, env string, timeout time.Duration) (*LoadBalancer, error) {
+	deadline := time.Now().Add(timeout)
+	lastState := "unknown"
+	for {
+		result, err := client.getLoadBalancerStatus(ctx, shipment, env)
+		if err != nil {
+			return nil, err
+		}
+
+		//load balancer state should go from "provisioning" to "active"
+		if result != nil {
+
+			//exit polling loop when active
+			if strings.HasPrefix(result.State, "active") {
+				return result, nil
+			}
+
+			if result.State != "provisioning" {
+				return nil, errors.New("LB state = " + result.State)
+			}
+			lastState = result.State
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %v waiting for the load balancer of %v to become active (last state: %v)%v",
+				timeout, envID(shipment, env), lastState, describeContainerStatuses(ctx, client, d.Get("barge").(string), shipment, env))
+		}
+
+		//wait a few seconds (or stop if terraform was interrupted)
+		logDebug("waiting for load balancer of %v (state: %v)", envID(shipment, env), lastState)
+		if err := sleep(ctx, pollInterval(d)); err != nil {
+			return nil, fmt.Errorf("stopped waiting for load balancer: %v", err)
+		}
+	}
+}
+
+//describeContainerStatuses returns one line per container (for error messages)
+func describeContainerStatuses(ctx context.Context, client *HarborClient, barge string, shipment string, env string) string {
+	status, err := client.GetShipmentStatus(ctx, barge, shipment, env)
+	if err != nil {
+		return fmt.Sprintf("\ncontainer status unavailable: %v", err)
+	}
+	if len(status.Status.Containers) == 0 {
+		return fmt.Sprintf("\nno containers are running (phase: %v)", status.Status.Phase)
+	}
+
+	lines := []string{fmt.Sprintf("\ncontainers (phase: %v):", status.Status.Phase)}
+	for _, c := range status.Status.Containers {
+		line := fmt.Sprintf("  %v %v: status=%v ready=%v restarts=%v", c.ID, c.Image, c.Status, c.Ready, c.Restarts)
+		for state, s := range c.State {
+			if s.Reason != "" {
+				line += fmt.Sprintf(" state=%v (%v: %v)", state, s.Reason, s.Message)
+			}
+		}
+		for state, s := range c.LastState {
+			line += fmt.Sprintf(" last=%v (exit code %v: %v)", state, s.ExitCode, s.Reason)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+//waitForHealthy polls helmit until enough replicas of every container are ready. if the rollout doesn't
+//become healthy within timeout, the error includes each container's last exit code and reason.
+func waitForHealthy(ctx context.Context, client *HarborClient, d *schema.ResourceData, shipment string, env string) error {
+	replicas := d.Get("replicas").(int)
+	if replicas == 0 {
+		return nil
+	}
+
+	//0 means every replica
+	minReady := d.Get("min_ready_replicas").(int)
+	if minReady <= 0 || minReady > replicas {
+		minReady = replicas
+	}
+
+	containers := len(d.Get("container").([]interface{}))
+	if containers == 0 {
+		containers = 1
+	}
+
+	timeout := time.Duration(d.Get("healthy_timeout").(int)) * time.Second
+	deadline := time.Now().Add(timeout)
+	barge := d.Get("barge").(string)
+	for {
+		status, err := client.GetShipmentStatus(ctx, barge, shipment, env)
+		if err != nil {
+			return err
+		}
+
+		ready := 0
+		for _, c := range status.Status.Containers {
+			if c.Ready {
+				ready++
+			}
+		}
+
+		//a replica is ready when all of its containers are
+		readyReplicas := ready / containers
+		if status.Status.Phase == "Running" && readyReplicas >= minReady {
+			logInfo("%v is healthy (%v/%v replicas ready)", envID(shipment, env), readyReplicas, replicas)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for %v to become healthy (%v/%v replicas ready, %v required)%v",
+				timeout, envID(shipment, env), readyReplicas, replicas, minReady, describeContainerStatuses(ctx, client, barge, shipment, env))
+		}
+
+		logDebug("waiting for %v to become healthy (phase: %v, %v/%v replicas ready)", envID(shipment, env), status.Status.Phase, readyReplicas, replicas)
+		if err := sleep(ctx, pollInterval(d)); err != nil {
+			return fmt.Errorf("stopped waiting for %v to become healthy: %v", envID(shipment, env), err)
+		}
+	}
+}
+
+//waitForDrained polls until helmit reports no running containers and the load balancer has been torn down
+func waitForDrained(ctx context.Context, client *HarborClient, barge string, shipment string, env string, timeout time.Duration, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		running, err := runningContainers(ctx, client, barge, shipment, env)
+		if err != nil {
+			return err
+		}
+
+		lbState := ""
+		if running == 0 {
+			lbState, err = loadBalancerState(ctx, client, shipment, env)
+			if err != nil {
+				return err
+			}
+			if lbState == "" {
+				logInfo("%v has been drained", envID(shipment, env))
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			msg := fmt.Sprintf("timed out after %v waiting for %v to drain (%v containers running", timeout, envID(shipment, env), running)
+			if lbState != "" {
+				msg += fmt.Sprintf(", load balancer state: %v", lbState)
+			}
+			return errors.New(msg + ")" + describeContainerStatuses(ctx, client, barge, shipment, env))
+		}
+
+		logDebug("waiting for %v to drain (%v containers running, load balancer state: %v)", envID(shipment, env), running, lbState)
+		if err := sleep(ctx, interval); err != nil {
+			return fmt.Errorf("stopped waiting for %v to drain: %v", envID(shipment, env), err)
+		}
+	}
+}
+
+//runningContainers returns the number of containers that helmit reports as running
+func runningContainers(ctx context.Context, client *HarborClient, barge string, shipment string, env string) (int, error) {
+	status, err := client.GetShipmentStatus(ctx, barge, shipment, env)
+	if err != nil {
+		if _, ok := err.(*NotFoundError); ok {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	running := 0
+	for _, c := range status.Status.Containers {
+		if _, ok := c.State["running"]; ok {
+			running++
+		}
+	}
+	return running, nil
+}
+
+//loadBalancerState returns the load balancer's state, or "" if it no longer exists
+func loadBalancerState(ctx context.Context, client *HarborClient, shipment string, env string) (string, error) {
+	lb, err := client.getLoadBalancerStatus(ctx, shipment, env)
+	if err != nil {
+		if _, ok := err.(*NotFoundError); ok {
+			return "", nil
+		}
+		return "", err
+	}
+	if lb == nil || lb.Name == "" || lb.State == "deleted" {
+		return "", nil
+	}
+	return lb.State, nil
+}